@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A backgroundCollection periodically collects the metrics of a single target
+// and keeps the registry of the last collection around, so that scrapes can be
+// answered without hitting the mailcow API every time.
+type backgroundCollection struct {
+	target target
+
+	mutex          sync.RWMutex
+	metrics        collectedMetrics
+	lastCollection time.Time
+
+	// Metrics about the collection itself. These are served even if the last
+	// collection is stale.
+	meta      *prometheus.Registry
+	timestamp prometheus.Gauge
+
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// Background collections are only started on start-up, so that requests cannot add
+// collections that keep polling the API. The map is not modified afterwards.
+var backgroundCollections = make(map[target]*backgroundCollection)
+
+// Starts collecting the given target in the background.
+func startBackgroundCollection(t target) {
+	if _, ok := backgroundCollections[t]; ok {
+		return
+	}
+
+	collection := newBackgroundCollection(t)
+	backgroundCollections[t] = collection
+	go collection.run()
+}
+
+// Returns the background collection of the given target or nil if the target
+// is not collected in the background.
+func backgroundCollectionOf(t target) *backgroundCollection {
+	return backgroundCollections[t]
+}
+
+func newBackgroundCollection(t target) *backgroundCollection {
+	timestamp := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "mailcow_exporter_last_collection_timestamp",
+		Help:        "Unix timestamp of the last completed background collection",
+		ConstLabels: map[string]string{"host": t.Host},
+	})
+	meta := prometheus.NewRegistry()
+	meta.MustRegister(timestamp)
+
+	return &backgroundCollection{
+		target:    t,
+		meta:      meta,
+		timestamp: timestamp,
+		ready:     make(chan struct{}),
+	}
+}
+
+func (collection *backgroundCollection) run() {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		collection.collect()
+		<-ticker.C
+	}
+}
+
+func (collection *backgroundCollection) collect() {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	defer collection.readyOnce.Do(func() { close(collection.ready) })
+
+	metrics, err := collectMetrics(ctx, collection.target)
+	if err != nil {
+		log.Printf("Could not collect metrics of %s: %s", collection.target.Host, err.Error())
+		return
+	}
+	now := time.Now()
+
+	collection.mutex.Lock()
+	collection.metrics = metrics
+	collection.lastCollection = now
+	collection.mutex.Unlock()
+
+	collection.timestamp.Set(float64(now.Unix()))
+}
+
+// Returns a gatherer serving the metrics of the last collection. Waits for the
+// first collection to finish if there has not been one yet.
+// If the last collection is older than the configured staleness, only metrics
+// about the collection itself are served so that outdated values are not
+// reported as current ones.
+// The collection always runs all providers of its target. If the given target selects
+// fewer providers (using `collect[]`), only the metrics of these are served.
+func (collection *backgroundCollection) Gatherer(ctx context.Context, selected target) prometheus.Gatherer {
+	select {
+	case <-collection.ready:
+	case <-ctx.Done():
+		return collection.meta
+	}
+
+	collection.mutex.RLock()
+	defer collection.mutex.RUnlock()
+
+	if collection.metrics.registry == nil {
+		return collection.meta
+	}
+
+	if time.Since(collection.lastCollection) > staleness {
+		log.Printf(
+			"Last collection of %s is stale (%s old), serving no metrics",
+			collection.target.Host,
+			time.Since(collection.lastCollection).Round(time.Second),
+		)
+		return collection.meta
+	}
+
+	if selected.Providers == collection.target.Providers {
+		return prometheus.Gatherers{collection.meta, collection.metrics.registry}
+	}
+
+	names := make([]string, 0)
+	for _, provider := range selected.enabledProviders() {
+		names = append(names, provider.Name)
+	}
+
+	return prometheus.Gatherers{collection.meta, collection.metrics.gatherer(names)}
+}
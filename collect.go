@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/j6s/mailcow-exporter/provider"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Error classes of provider errors that do not originate from the API
+const (
+	// The provider could not parse a value returned by the API
+	errorClassParse = "parse"
+	// The provider did not finish in time
+	errorClassTimeout = "timeout"
+	// The scrape was cancelled, e.g. because Prometheus closed the connection
+	errorClassCanceled = "canceled"
+	// The collectors of the provider could not be registered
+	errorClassRegister = "register"
+	// The provider could not connect to a port of the host
+	errorClassProbe = "probe"
+)
+
+// Result of a single provider run
+type providerResult struct {
+	collectors []prometheus.Collector
+	err        error
+	timedOut   bool
+	duration   time.Duration
+}
+
+// Metrics of a single collection. The collectors of every provider are additionally kept
+// in a registry of their own, so that background collections can serve a subset of the providers.
+type collectedMetrics struct {
+	// All metrics of the collection
+	registry *prometheus.Registry
+	// Metrics that do not belong to a single provider, e.g. of the API client
+	common *prometheus.Registry
+	// Metrics of every provider by its name
+	providers map[string]*prometheus.Registry
+}
+
+// Returns a gatherer of the metrics of the given providers. Metrics of the exporter
+// itself are filtered by their `provider` label, API metrics are always included.
+func (metrics collectedMetrics) gatherer(names []string) prometheus.Gatherer {
+	selected := make(map[string]bool)
+	gatherers := prometheus.Gatherers{providerFilter{metrics.common, selected}}
+	for _, name := range names {
+		selected[name] = true
+		if registry, ok := metrics.providers[name]; ok {
+			gatherers = append(gatherers, registry)
+		}
+	}
+
+	return gatherers
+}
+
+// Gatherer that drops metrics with a `provider` label that is not selected
+type providerFilter struct {
+	gatherer prometheus.Gatherer
+	selected map[string]bool
+}
+
+func (filter providerFilter) Gather() ([]*dto.MetricFamily, error) {
+	families, err := filter.gatherer.Gather()
+
+	filtered := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		metrics := make([]*dto.Metric, 0, len(family.Metric))
+		for _, metric := range family.Metric {
+			if filter.keeps(metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+		if len(metrics) > 0 {
+			family.Metric = metrics
+			filtered = append(filtered, family)
+		}
+	}
+
+	return filtered, err
+}
+
+func (filter providerFilter) keeps(metric *dto.Metric) bool {
+	for _, label := range metric.Label {
+		if label.GetName() == "provider" {
+			return filter.selected[label.GetValue()]
+		}
+	}
+
+	return true
+}
+
+// Provider errors are counted across collections, hence the counters are kept per host.
+// Hosts whose state is not kept (see `mailcowApi.KeepsState`) only count the errors of a single collection.
+var (
+	providerErrors      = make(map[string]*prometheus.CounterVec)
+	providerErrorsMutex sync.Mutex
+)
+
+func providerErrorsFor(host string) *prometheus.CounterVec {
+	providerErrorsMutex.Lock()
+	defer providerErrorsMutex.Unlock()
+
+	counter, ok := providerErrors[host]
+	if !ok {
+		counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "mailcow_exporter_provider_errors_total",
+			Help:        "Number of errors that occurred while running the provider by class of the error",
+			ConstLabels: map[string]string{"host": host},
+		}, []string{"provider", "class"})
+		if mailcowApi.KeepsState(host) {
+			providerErrors[host] = counter
+		}
+	}
+
+	return counter
+}
+
+// Determines the class of an error returned by a provider.
+// Requests that failed because the scrape was cancelled are not counted as API errors.
+func errorClass(ctx context.Context, err error) string {
+	if errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
+		return errorClassCanceled
+	}
+
+	var apiError *mailcowApi.ApiError
+	if errors.As(err, &apiError) {
+		return apiError.Class
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errorClassTimeout
+	}
+	var probeError *provider.ProbeError
+	if errors.As(err, &probeError) {
+		return errorClassProbe
+	}
+
+	// Everything else is returned by providers while parsing the API response.
+	return errorClassParse
+}
+
+// Runs the given provider, giving up once the context is done.
+// The context is passed on to the API requests of the provider, which are
+// cancelled as well. The result of a provider that did not finish in time
+// is discarded.
+func runProvider(ctx context.Context, provider Provider, apiClient mailcowApi.MailcowApiClient) providerResult {
+	start := time.Now()
+	done := make(chan providerResult, 1)
+
+	go func() {
+		collectors, err := provider.Provide(ctx, apiClient)
+		done <- providerResult{collectors: collectors, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		result.duration = time.Since(start)
+		return result
+	case <-ctx.Done():
+		return providerResult{
+			err:      ctx.Err(),
+			timedOut: ctx.Err() == context.DeadlineExceeded,
+			duration: time.Since(start),
+		}
+	}
+}
+
+func collectMetrics(ctx context.Context, t target) (collectedMetrics, error) {
+	options := apiOptions
+	options.TLS = t.TLS
+	apiClient, err := mailcowApi.NewMailcowApiClient(t.Scheme, t.Host, t.ApiKey, options)
+	if err != nil {
+		return collectedMetrics{}, err
+	}
+	host := t.Host
+
+	success := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_exporter_success",
+		Help:        "1, if the provider was successful, 0 if not. `timeout` is 1 if the provider did not finish in time",
+		ConstLabels: map[string]string{"host": host},
+	}, []string{"provider", "timeout"})
+	duration := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_exporter_provider_duration_seconds",
+		Help:        "Time it took the provider to gather its metrics in seconds",
+		ConstLabels: map[string]string{"host": host},
+	}, []string{"provider"})
+	errorCount := providerErrorsFor(host)
+
+	metrics := collectedMetrics{
+		registry:  prometheus.NewRegistry(),
+		common:    prometheus.NewRegistry(),
+		providers: make(map[string]*prometheus.Registry),
+	}
+	for _, collector := range []prometheus.Collector{success, duration, errorCount} {
+		metrics.registry.Register(collector)
+		metrics.common.Register(collector)
+	}
+
+	enabled := t.enabledProviders()
+
+	// All providers run concurrently, so that a single slow endpoint does not delay all others.
+	results := make([]providerResult, len(enabled))
+	var wg sync.WaitGroup
+	for i, provider := range enabled {
+		wg.Add(1)
+		go func(i int, provider Provider) {
+			defer wg.Done()
+			results[i] = runProvider(ctx, provider, apiClient)
+		}(i, provider.Provider)
+	}
+	wg.Wait()
+
+	for i, provider := range enabled {
+		result := results[i]
+		providerSuccess := true
+		if result.err != nil {
+			providerSuccess = false
+			errorCount.WithLabelValues(provider.Name, errorClass(ctx, result.err)).Inc()
+			log.Printf(
+				"Error while updating metrics of %s:\n%s",
+				provider.Name,
+				result.err.Error(),
+			)
+		}
+
+		metrics.providers[provider.Name] = prometheus.NewRegistry()
+		for _, collector := range result.collectors {
+			err := metrics.registry.Register(collector)
+			if err == nil {
+				metrics.providers[provider.Name].Register(collector)
+			} else {
+				providerSuccess = false
+				errorCount.WithLabelValues(provider.Name, errorClassRegister).Inc()
+				log.Printf(
+					"Error while updating metrics of %s:\n%s",
+					provider.Name,
+					err.Error(),
+				)
+			}
+		}
+
+		timedOut := "0"
+		if result.timedOut {
+			timedOut = "1"
+		}
+
+		if providerSuccess {
+			success.WithLabelValues(provider.Name, timedOut).Set(1.0)
+		} else {
+			success.WithLabelValues(provider.Name, timedOut).Set(0.0)
+		}
+		duration.WithLabelValues(provider.Name).Set(result.duration.Seconds())
+	}
+
+	for _, collector := range apiClient.Provide() {
+		metrics.registry.Register(collector)
+		metrics.common.Register(collector)
+	}
+
+	return metrics, nil
+}
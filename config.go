@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/j6s/mailcow-exporter/provider"
+	"gopkg.in/yaml.v2"
+)
+
+// Format of the configuration file passed using the `config` flag.
+type config struct {
+	Targets []targetConfig `yaml:"targets"`
+}
+
+// A single named target in the configuration file
+type targetConfig struct {
+	Name        string            `yaml:"name"`
+	Host        string            `yaml:"host"`
+	Scheme      string            `yaml:"scheme"`
+	ApiKey      string            `yaml:"api_key"`
+	ApiKeyFile  string            `yaml:"api_key_file"`
+	Providers   []string          `yaml:"providers"`
+	TLS         tlsConfig         `yaml:"tls"`
+	Certificate certificateConfig `yaml:"certificate"`
+}
+
+// TLS options of a target. Options that are not set fall back to
+// the ones set by flags or environment.
+type tlsConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify *bool  `yaml:"insecure_skip_verify"`
+}
+
+// Options of the certificate provider for a target. Options that are not set
+// fall back to the ones set by flags or environment.
+type certificateConfig struct {
+	Host       string   `yaml:"host"`
+	ServerName string   `yaml:"server_name"`
+	Ports      []string `yaml:"ports"`
+}
+
+// Loads the configuration file and converts it to targets indexed by their name.
+func loadConfig(path string) (map[string]target, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read configuration file `%s`: %s", path, err.Error())
+	}
+
+	parsed := config{}
+	err = yaml.UnmarshalStrict(content, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse configuration file `%s`: %s", path, err.Error())
+	}
+
+	targets := make(map[string]target)
+	for _, targetConfig := range parsed.Targets {
+		if targetConfig.Name == "" {
+			return nil, fmt.Errorf("Every target in `%s` requires a `name`", path)
+		}
+		if _, ok := targets[targetConfig.Name]; ok {
+			return nil, fmt.Errorf("Target `%s` is defined multiple times in `%s`", targetConfig.Name, path)
+		}
+
+		t, err := targetConfig.target()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid target `%s` in `%s`: %s", targetConfig.Name, path, err.Error())
+		}
+		targets[targetConfig.Name] = t
+
+		// The options of the certificate provider are stored in the provider itself,
+		// since targets only carry what is needed to connect to the API.
+		options := targetConfig.Certificate.options(certificate.Default)
+		if err := options.Validate(); err != nil {
+			return nil, fmt.Errorf("Invalid certificate options of target `%s` in `%s`: %s", targetConfig.Name, path, err.Error())
+		}
+		certificate.Targets[t.Host] = options
+	}
+
+	return targets, nil
+}
+
+func (config targetConfig) target() (target, error) {
+	if config.Host == "" {
+		return target{}, fmt.Errorf("`host` is required")
+	}
+
+	scheme := config.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	apiKey := config.ApiKey
+	if config.ApiKeyFile != "" {
+		content, err := ioutil.ReadFile(config.ApiKeyFile)
+		if err != nil {
+			return target{}, fmt.Errorf("Could not read `api_key_file`: %s", err.Error())
+		}
+		apiKey = strings.TrimSpace(string(content))
+	}
+	if apiKey == "" {
+		return target{}, fmt.Errorf("`api_key` or `api_key_file` is required")
+	}
+
+	for _, name := range config.Providers {
+		if providerByName(name) == nil {
+			return target{}, fmt.Errorf("Unknown provider `%s`", name)
+		}
+	}
+
+	tlsOptions := config.TLS.options(apiOptions.TLS)
+	if _, err := tlsOptions.Config(); err != nil {
+		return target{}, err
+	}
+
+	return target{
+		Scheme:    scheme,
+		Host:      config.Host,
+		ApiKey:    apiKey,
+		TLS:       tlsOptions,
+		Providers: strings.Join(config.Providers, ","),
+	}, nil
+}
+
+func (config tlsConfig) options(defaults mailcowApi.TLSOptions) mailcowApi.TLSOptions {
+	options := defaults
+	if config.CAFile != "" {
+		options.CAFile = config.CAFile
+	}
+	if config.CertFile != "" {
+		options.CertFile = config.CertFile
+	}
+	if config.KeyFile != "" {
+		options.KeyFile = config.KeyFile
+	}
+	if config.ServerName != "" {
+		options.ServerName = config.ServerName
+	}
+	if config.InsecureSkipVerify != nil {
+		options.InsecureSkipVerify = *config.InsecureSkipVerify
+	}
+
+	return options
+}
+
+func (config certificateConfig) options(defaults provider.CertificateOptions) provider.CertificateOptions {
+	options := defaults
+	if config.Host != "" {
+		options.Host = config.Host
+	}
+	if config.ServerName != "" {
+		options.ServerName = config.ServerName
+	}
+	if len(config.Ports) > 0 {
+		options.Endpoints = config.Ports
+	}
+
+	return options
+}
@@ -2,4 +2,8 @@ module github.com/j6s/mailcow-exporter
 
 go 1.14
 
-require github.com/prometheus/client_golang v1.7.1
+require (
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -1,32 +1,105 @@
 package mailcowApi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Options for the HTTP connections to the mailcow API
+type ClientOptions struct {
+	// Maximum time a single request may take, including reading the response body
+	Timeout time.Duration
+	// Interval of TCP keep-alive probes
+	KeepAlive time.Duration
+	// Maximum time an idle connection is kept open for reuse
+	IdleConnTimeout time.Duration
+	// Maximum number of idle connections kept open for reuse per host
+	MaxIdleConns int
+	// TLS options for HTTPS connections
+	TLS TLSOptions
+	// Retry options for failed requests
+	Retry RetryOptions
+	// Options of the circuit breaker of every host
+	Breaker BreakerOptions
+}
+
+// HTTP clients are shared by all API clients with the same options,
+// so that connections to the mailcow API are reused across requests.
+// Options must only be set on start-up, since clients are never removed.
+var (
+	httpClients      = make(map[ClientOptions]*http.Client)
+	httpClientsMutex sync.Mutex
+)
+
+// Returns the HTTP client for the given options, creating it if it does not exist yet.
+func httpClientFor(options ClientOptions) (*http.Client, error) {
+	httpClientsMutex.Lock()
+	defer httpClientsMutex.Unlock()
+
+	if client, ok := httpClients[options]; ok {
+		return client, nil
+	}
+
+	tlsConfig, err := options.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: options.Timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   options.Timeout,
+				KeepAlive: options.KeepAlive,
+			}).DialContext,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConns:        options.MaxIdleConns,
+			MaxIdleConnsPerHost: options.MaxIdleConns,
+			IdleConnTimeout:     options.IdleConnTimeout,
+			TLSHandshakeTimeout: options.Timeout,
+		},
+	}
+	httpClients[options] = client
+
+	return client, nil
+}
+
 // Client for mailcow API
 type MailcowApiClient struct {
 	Scheme       string
 	Host         string
 	ApiKey       string
+	HttpClient   *http.Client
 	ResponseTime prometheus.GaugeVec
 	ResponseSize prometheus.GaugeVec
 	Success      prometheus.GaugeVec
+	Requests     requestMetrics
+	Retry        RetryOptions
+	Breaker      *CircuitBreaker
 }
 
-func NewMailcowApiClient(scheme string, host string, apiKey string) MailcowApiClient {
+func NewMailcowApiClient(scheme string, host string, apiKey string, options ClientOptions) (MailcowApiClient, error) {
+	httpClient, err := httpClientFor(options)
+	if err != nil {
+		return MailcowApiClient{}, err
+	}
+
 	return MailcowApiClient{
-		Scheme: scheme,
-		Host:   host,
-		ApiKey: apiKey,
+		Scheme:     scheme,
+		Host:       host,
+		ApiKey:     apiKey,
+		HttpClient: httpClient,
 		ResponseTime: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name:        "mailcow_api_response_time",
 			Help:        "Response time of the API in milliseconds (1/1000s of a second)",
@@ -42,38 +115,120 @@ func NewMailcowApiClient(scheme string, host string, apiKey string) MailcowApiCl
 			Help:        "1, if request was sucessful, 0 if not",
 			ConstLabels: map[string]string{"host": host},
 		}, []string{"endpoint"}),
-	}
+		Requests: requestMetricsFor(host),
+		Retry:    options.Retry,
+		Breaker:  circuitBreakerFor(host, options.Breaker),
+	}, nil
 }
 
 // Given an endpoint, this method will do the HTTP request
 // with the correct authentication and unserialize the JSON
 // response into a given target reference.
-func (api MailcowApiClient) Get(endpoint string, target interface{}) error {
+// The request is aborted once the given context is done.
+// Transient failures are retried with exponential backoff. If the host failed
+// repeatedly, requests are not executed at all until the circuit breaker closes again.
+func (api MailcowApiClient) Get(ctx context.Context, endpoint string, target interface{}) error {
+	if !api.Breaker.Allow() {
+		api.Success.WithLabelValues(endpoint).Set(0.0)
+		return &ApiError{
+			Class:    ErrorClassCircuitOpen,
+			Endpoint: endpoint,
+			Err: fmt.Errorf(
+				"Not requesting endpoint `%s`: Circuit breaker for %s is open after repeated failures",
+				endpoint,
+				api.Host,
+			),
+		}
+	}
+
+	var body []byte
+	var err error
+	for attempt := 0; ; attempt++ {
+		body, err = api.request(ctx, endpoint)
+		if err == nil || !isRetryable(err) || attempt >= api.Retry.Retries {
+			break
+		}
+
+		backoff := api.Retry.backoff(attempt)
+		log.Printf("Request to `%s` failed, retrying in %s: %s", endpoint, backoff, err.Error())
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	switch {
+	case err == nil:
+		api.Breaker.Success()
+	case ctx.Err() != nil:
+		api.Breaker.Release()
+	case isRetryable(err):
+		api.Breaker.Failure()
+	default:
+		// The host responded, e.g. with a 404 or 500, so it is not down.
+		api.Breaker.Success()
+	}
+	if err != nil {
+		api.Success.WithLabelValues(endpoint).Set(0.0)
+		return err
+	}
+
+	err = json.Unmarshal(body, target)
+	if err != nil {
+		api.Success.WithLabelValues(endpoint).Set(0.0)
+		return &ApiError{
+			Class:    ErrorClassDecode,
+			Endpoint: endpoint,
+			Err: fmt.Errorf(
+				"Could not parse JSON response from endpoint `%s`: \n%s \n\nResponse body received: \n%s",
+				endpoint,
+				err.Error(),
+				body,
+			),
+		}
+	}
+
+	api.Success.WithLabelValues(endpoint).Set(1.0)
+	return nil
+}
+
+// Executes a single request to the given endpoint and returns the response body.
+func (api MailcowApiClient) request(ctx context.Context, endpoint string) ([]byte, error) {
 	url := fmt.Sprintf("%s://%s/%s", api.Scheme, api.Host, endpoint)
 	log.Print(url)
 
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		api.Success.WithLabelValues(endpoint).Set(0.0)
-		return fmt.Errorf(
-			"Could not prepare API request to `%s`: %#v",
-			endpoint,
-			err.Error(),
-		)
+		return nil, &ApiError{
+			Class:    ErrorClassHttp,
+			Endpoint: endpoint,
+			Err: fmt.Errorf(
+				"Could not prepare API request to `%s`: %#v",
+				endpoint,
+				err.Error(),
+			),
+		}
 	}
 
 	request.Header.Add("X-Api-Key", api.ApiKey)
 	start := time.Now()
 
 	// API Request
-	response, err := (&http.Client{}).Do(request)
+	response, err := api.HttpClient.Do(request)
 	if err != nil {
-		api.Success.WithLabelValues(endpoint).Set(0.0)
-		return fmt.Errorf(
-			"could not execute API request to `%s`: %#v",
-			endpoint,
-			err.Error(),
-		)
+		api.Requests.Requests.WithLabelValues(endpoint, "error").Inc()
+		return nil, &ApiError{
+			Class:    ErrorClassHttp,
+			Endpoint: endpoint,
+			Err: fmt.Errorf(
+				"could not execute API request to `%s`: %#v",
+				endpoint,
+				err.Error(),
+			),
+		}
 	}
 
 	// Metric collection about the API request
@@ -81,26 +236,25 @@ func (api MailcowApiClient) Get(endpoint string, target interface{}) error {
 	api.ResponseTime.
 		WithLabelValues(endpoint, statusCodeString).
 		Set(float64(time.Since(start).Milliseconds()))
-
-	// API Request error handling
-	if err != nil {
-		api.Success.WithLabelValues(endpoint).Set(0.0)
-		return fmt.Errorf(
-			"API Request to endpoint `%s` failed: \n%s",
-			endpoint,
-			err.Error(),
-		)
-	}
+	api.Requests.Duration.
+		WithLabelValues(endpoint, statusCodeString).
+		Observe(time.Since(start).Seconds())
+	api.Requests.Requests.
+		WithLabelValues(endpoint, statusCodeString).
+		Inc()
 
 	defer response.Body.Close()
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		api.Success.WithLabelValues(endpoint).Set(0.0)
-		return fmt.Errorf(
-			"Could not read API response body from endpoint `%s`: \n%s",
-			endpoint,
-			err.Error(),
-		)
+		return nil, &ApiError{
+			Class:    ErrorClassHttp,
+			Endpoint: endpoint,
+			Err: fmt.Errorf(
+				"Could not read API response body from endpoint `%s`: \n%s",
+				endpoint,
+				err.Error(),
+			),
+		}
 	}
 
 	api.ResponseSize.
@@ -108,31 +262,35 @@ func (api MailcowApiClient) Get(endpoint string, target interface{}) error {
 		Set(float64(len(body)))
 
 	if response.StatusCode != 200 {
-		api.Success.WithLabelValues(endpoint).Set(0.0)
-		return fmt.Errorf(
-			"Received %d response from endpoint `%s`: \n\nResponse body received: \n%s",
-			response.StatusCode,
-			endpoint,
-			body,
-		)
+		return nil, &ApiError{
+			Class:      ErrorClassStatus,
+			Endpoint:   endpoint,
+			StatusCode: response.StatusCode,
+			Err: fmt.Errorf(
+				"Received %d response from endpoint `%s`: \n\nResponse body received: \n%s",
+				response.StatusCode,
+				endpoint,
+				body,
+			),
+		}
 	}
 
-	err = json.Unmarshal(body, target)
-	if err != nil {
-		api.Success.WithLabelValues(endpoint).Set(0.0)
-		return fmt.Errorf(
-			"Could not parse JSON response from endpoint `%s`: \n%s \n\nResponse body received: \n%s",
-			endpoint,
-			err.Error(),
-			body,
-		)
-	}
+	return body, nil
+}
 
-	api.Success.WithLabelValues(endpoint).Set(1.0)
-	return nil
+// Returns whether the metrics and state of the host are kept for the lifetime of the exporter.
+func (api MailcowApiClient) KeepsState() bool {
+	return KeepsState(api.Host)
 }
 
 // Provides (meta) metrics about API endpoints
 func (api MailcowApiClient) Provide() []prometheus.Collector {
-	return []prometheus.Collector{api.ResponseSize, api.ResponseTime, api.Success}
+	return []prometheus.Collector{
+		api.ResponseSize,
+		api.ResponseTime,
+		api.Success,
+		api.Requests.Duration,
+		api.Requests.Requests,
+		api.Breaker.State,
+	}
 }
@@ -0,0 +1,50 @@
+package mailcowApi
+
+// Classes of errors that can occur while requesting the API
+const (
+	// The request could not be executed or the response could not be read
+	ErrorClassHttp = "http"
+	// The API responded with a non-200 status code
+	ErrorClassStatus = "status"
+	// The response could not be decoded as JSON
+	ErrorClassDecode = "decode"
+	// The request was not executed because the circuit breaker of the host is open
+	ErrorClassCircuitOpen = "circuit_open"
+)
+
+// Error returned by API requests, carrying the class of the error
+// in order to differentiate failures in metrics.
+type ApiError struct {
+	Class    string
+	Endpoint string
+	// Status code of the response, if any
+	StatusCode int
+	Err        error
+}
+
+func (err *ApiError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ApiError) Unwrap() error {
+	return err.Err
+}
+
+// Determines whether the error is transient and the request should be retried.
+// These are failed requests and gateway errors, which mailcow's nginx returns
+// while PHP-FPM is restarting.
+func isRetryable(err error) bool {
+	apiError, ok := err.(*ApiError)
+	if !ok {
+		return false
+	}
+
+	switch apiError.Class {
+	case ErrorClassHttp:
+		return true
+	case ErrorClassStatus:
+		return apiError.StatusCode == 502 || apiError.StatusCode == 503 || apiError.StatusCode == 504
+	}
+
+	return false
+}
@@ -0,0 +1,19 @@
+package mailcowApi
+
+// Hosts whose metrics and state are kept for the lifetime of the exporter, e.g. request
+// counters and circuit breakers. Hosts passed as URL parameters are not among them, so that
+// requests cannot grow the memory and the number of series of the exporter.
+// The map is only modified on start-up.
+var persistentHosts = make(map[string]bool)
+
+// Keeps the metrics and state of the given host for the lifetime of the exporter.
+// Must only be called on start-up, for the default host and the targets of the configuration file.
+func KeepState(host string) {
+	persistentHosts[host] = true
+}
+
+// Returns whether the metrics and state of the given host are kept for the lifetime of the exporter.
+// Otherwise, they only live for a single collection.
+func KeepsState(host string) bool {
+	return persistentHosts[host]
+}
@@ -0,0 +1,52 @@
+package mailcowApi
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics about API requests that are kept for the lifetime of the exporter
+// instead of a single collection, so that rates and quantiles can be calculated.
+type requestMetrics struct {
+	Duration *prometheus.HistogramVec
+	Requests *prometheus.CounterVec
+}
+
+// Request metrics are shared by all API clients of the same host,
+// if the state of the host is kept (see `KeepsState`).
+var (
+	hostRequestMetrics      = make(map[string]requestMetrics)
+	hostRequestMetricsMutex sync.Mutex
+)
+
+func requestMetricsFor(host string) requestMetrics {
+	hostRequestMetricsMutex.Lock()
+	defer hostRequestMetricsMutex.Unlock()
+
+	metrics, ok := hostRequestMetrics[host]
+	if !ok {
+		metrics = newRequestMetrics(host)
+		if KeepsState(host) {
+			hostRequestMetrics[host] = metrics
+		}
+	}
+
+	return metrics
+}
+
+func newRequestMetrics(host string) requestMetrics {
+	return requestMetrics{
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "mailcow_api_request_duration_seconds",
+			Help:        "Duration of requests to the API in seconds",
+			Buckets:     []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			ConstLabels: map[string]string{"host": host},
+		}, []string{"endpoint", "code"}),
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "mailcow_api_requests_total",
+			Help:        "Number of requests to the API. `code` is `error` if no response was received",
+			ConstLabels: map[string]string{"host": host},
+		}, []string{"endpoint", "code"}),
+	}
+}
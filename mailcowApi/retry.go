@@ -0,0 +1,158 @@
+package mailcowApi
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options for retrying failed requests
+type RetryOptions struct {
+	// Number of retries after the first attempt. 0 disables retries.
+	Retries int
+	// Backoff before the first retry. Doubled for every further retry.
+	Backoff time.Duration
+	// Maximum backoff between two retries
+	MaxBackoff time.Duration
+}
+
+// Returns the time to wait before the given retry using exponential
+// backoff with full jitter.
+func (options RetryOptions) backoff(attempt int) time.Duration {
+	backoff := options.Backoff << uint(attempt)
+	if backoff <= 0 || (options.MaxBackoff > 0 && backoff > options.MaxBackoff) {
+		backoff = options.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// Options for the circuit breaker
+type BreakerOptions struct {
+	// Number of consecutive failures after which the circuit breaker opens. 0 disables it.
+	Threshold int
+	// Time the circuit breaker stays open before requests are attempted again
+	Cooldown time.Duration
+}
+
+// States of the circuit breaker as exported in the state metric
+const (
+	BreakerClosed   = 0
+	BreakerHalfOpen = 1
+	BreakerOpen     = 2
+)
+
+// A CircuitBreaker short-circuits requests to a host after repeated failures,
+// in order to not pile up requests to a host that is down. After the cooldown,
+// a single trial request is let through (half-open). Its result decides whether
+// the breaker closes or opens again.
+type CircuitBreaker struct {
+	options BreakerOptions
+
+	mutex    sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	// Whether the trial request of the half-open circuit breaker is in flight
+	trial bool
+
+	State prometheus.Gauge
+}
+
+// Circuit breakers are kept per host across all API clients, if the state of the host is kept
+// (see `KeepsState`). Otherwise, the circuit breaker only applies to a single collection.
+var (
+	circuitBreakers      = make(map[string]*CircuitBreaker)
+	circuitBreakersMutex sync.Mutex
+)
+
+func circuitBreakerFor(host string, options BreakerOptions) *CircuitBreaker {
+	circuitBreakersMutex.Lock()
+	defer circuitBreakersMutex.Unlock()
+
+	breaker, ok := circuitBreakers[host]
+	if !ok {
+		breaker = &CircuitBreaker{
+			options: options,
+			State: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        "mailcow_api_circuit_breaker_state",
+				Help:        "State of the circuit breaker for the API: 0 = closed, 1 = half-open, 2 = open",
+				ConstLabels: map[string]string{"host": host},
+			}),
+		}
+		if KeepsState(host) {
+			circuitBreakers[host] = breaker
+		}
+	}
+
+	return breaker
+}
+
+// Returns whether a request may be executed.
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state == BreakerOpen && time.Since(breaker.openedAt) >= breaker.options.Cooldown {
+		breaker.setState(BreakerHalfOpen)
+	}
+
+	switch breaker.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if breaker.trial {
+			return false
+		}
+		breaker.trial = true
+	}
+
+	return true
+}
+
+// Records a request the host responded to, closing the circuit breaker.
+func (breaker *CircuitBreaker) Success() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.trial = false
+	breaker.failures = 0
+	breaker.setState(BreakerClosed)
+}
+
+// Records a failed request, opening the circuit breaker if the threshold is reached
+// or the trial request of a half-open circuit breaker failed.
+func (breaker *CircuitBreaker) Failure() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.trial = false
+	if breaker.options.Threshold <= 0 {
+		return
+	}
+
+	breaker.failures++
+	if breaker.state == BreakerHalfOpen || breaker.failures >= breaker.options.Threshold {
+		breaker.openedAt = time.Now()
+		breaker.setState(BreakerOpen)
+	}
+}
+
+// Records a request without result, e.g. because it was cancelled.
+// A half-open circuit breaker lets the next request through as trial.
+func (breaker *CircuitBreaker) Release() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.trial = false
+}
+
+func (breaker *CircuitBreaker) setState(state int) {
+	breaker.state = state
+	breaker.State.Set(float64(state))
+}
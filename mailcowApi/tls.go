@@ -0,0 +1,57 @@
+package mailcowApi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLS options for the connection to the mailcow API
+type TLSOptions struct {
+	// PEM encoded CA bundle used to verify the server certificate instead of the system roots
+	CAFile string
+	// PEM encoded client certificate and key used for mutual TLS
+	CertFile string
+	KeyFile  string
+	// Server name used to verify the server certificate instead of the host
+	ServerName string
+	// Disables verification of the server certificate
+	InsecureSkipVerify bool
+}
+
+// Builds the TLS configuration described by the options.
+func (options TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA bundle `%s`: %s", options.CAFile, err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle `%s` does not contain any PEM encoded certificate", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"Could not load client certificate `%s` with key `%s`: %s",
+				options.CertFile,
+				options.KeyFile,
+				err.Error(),
+			)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
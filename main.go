@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/j6s/mailcow-exporter/mailcowApi"
 	"github.com/j6s/mailcow-exporter/provider"
@@ -18,129 +22,431 @@ var (
 	defaultHost   string
 	defaultApiKey string
 	listen        string
+	interval      time.Duration
+	staleness     time.Duration
+	timeout       time.Duration
+	timeoutOffset time.Duration
+	apiOptions    mailcowApi.ClientOptions
+	configFile    string
+	targets       map[string]target
+
+	// Names of the providers that are enabled by flags
+	collectorEnabled = make(map[string]bool)
 )
 
+// A target is a single mailcow instance metrics are collected from.
+type target struct {
+	Scheme string
+	Host   string
+	ApiKey string
+	TLS    mailcowApi.TLSOptions
+	// Comma separated names of the enabled providers. All providers are enabled if empty.
+	Providers string
+}
+
+// Returns the providers that are enabled for the target and by flags.
+func (t target) enabledProviders() []namedProvider {
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(t.Providers, ",") {
+		selected[name] = true
+	}
+
+	enabled := make([]namedProvider, 0)
+	for _, provider := range providers {
+		if !collectorEnabled[provider.Name] {
+			continue
+		}
+		if t.Providers == "" || selected[provider.Name] {
+			enabled = append(enabled, provider)
+		}
+	}
+
+	return enabled
+}
+
 // A Provider is the common abstraction over collection of metrics in this
 // exporter. It can provide one or more prometheus collectors (e.g. gauges,
 // histograms, ...) that are updated every time the `Update` method is called.
 // Be sure to keep a copy of the collectors returned by `GetCollectors`
 // in your provider in order to update that same instance.
 type Provider interface {
-	Provide(mailcowApi.MailcowApiClient) ([]prometheus.Collector, error)
+	Provide(context.Context, mailcowApi.MailcowApiClient) ([]prometheus.Collector, error)
+}
+
+// A provider together with the name it is referred to in the configuration
+type namedProvider struct {
+	Name     string
+	Provider Provider
 }
 
-// Provider setup. Every provider in this array will be used for gathering metrics.
+// Provider setup. Every provider in this array will be used for gathering metrics,
+// unless a target only enables some of them.
+// Providers that need configuration are kept in variables, so that flags can set their options.
 var (
-	providers = []Provider{
-		provider.Mailq{},
-		provider.Mailbox{},
-		provider.Quarantine{},
-		provider.Container{},
-		provider.Rspamd{},
-		provider.Domain{},
+	versionProvider = &provider.Version{}
+	cardinality     = &provider.Cardinality{}
+	rspamdHistory   = &provider.RspamdHistory{}
+	certificate     = &provider.Certificate{Targets: make(map[string]provider.CertificateOptions)}
+
+	providers = []namedProvider{
+		{"mailq", provider.Mailq{Cardinality: cardinality}},
+		{"mailbox", provider.Mailbox{Cardinality: cardinality}},
+		{"syncjob", provider.Syncjob{}},
+		{"quarantine", provider.Quarantine{Cardinality: cardinality}},
+		{"container", &provider.Container{}},
+		{"rspamd", provider.Rspamd{}},
+		{"fail2ban", &provider.Fail2ban{}},
+		{"domain", provider.Domain{}},
+		{"alias", provider.Alias{}},
+		{"vmail", provider.Vmail{}},
+		{"version", versionProvider},
+		{"postfix", &provider.Postfix{}},
+		{"dovecot", &provider.Dovecot{}},
+		{"sogo", &provider.Sogo{}},
+		{"rspamd-history", rspamdHistory},
+		{"acme", &provider.Acme{}},
+		{"certificate", certificate},
+	}
+
+	// Providers that have to be enabled explicitly, since they do not use the mailcow API
+	optionalProviders = map[string]bool{
+		"certificate": true,
 	}
 )
 
+// Returns the provider with the given name or nil if there is none.
+func providerByName(name string) *namedProvider {
+	for i := range providers {
+		if providers[i].Name == name {
+			return &providers[i]
+		}
+	}
+
+	return nil
+}
+
 func parseFlagsAndEnv() {
+	envConfig, _ := os.LookupEnv("MAILCOW_EXPORTER_CONFIG")
+	envReleasesFile, _ := os.LookupEnv("MAILCOW_EXPORTER_RELEASES_FILE")
 	envHost, _ := os.LookupEnv("MAILCOW_EXPORTER_HOST")
 	envApiKey, _ := os.LookupEnv("MAILCOW_EXPORTER_API_KEY")
 	defaultListen, _ := os.LookupEnv("MAILCOW_EXPORTER_LISTEN")
 	if defaultListen == "" {
 		defaultListen = ":9099"
 	}
+	envInterval := durationFromEnv("MAILCOW_EXPORTER_INTERVAL")
+	envStaleness := durationFromEnv("MAILCOW_EXPORTER_STALENESS")
+	envTLSCAFile, _ := os.LookupEnv("MAILCOW_EXPORTER_TLS_CA_FILE")
+	envTLSCertFile, _ := os.LookupEnv("MAILCOW_EXPORTER_TLS_CERT_FILE")
+	envTLSKeyFile, _ := os.LookupEnv("MAILCOW_EXPORTER_TLS_KEY_FILE")
+	envTLSServerName, _ := os.LookupEnv("MAILCOW_EXPORTER_TLS_SERVER_NAME")
+	envTLSInsecureSkipVerify := boolFromEnv("MAILCOW_EXPORTER_TLS_INSECURE_SKIP_VERIFY")
+	envAggregation, _ := os.LookupEnv("MAILCOW_EXPORTER_CARDINALITY_AGGREGATION")
+	envAllowUsers, _ := os.LookupEnv("MAILCOW_EXPORTER_CARDINALITY_ALLOW_USERS")
+	envDenyUsers, _ := os.LookupEnv("MAILCOW_EXPORTER_CARDINALITY_DENY_USERS")
+	envAllowDomains, _ := os.LookupEnv("MAILCOW_EXPORTER_CARDINALITY_ALLOW_DOMAINS")
+	envDenyDomains, _ := os.LookupEnv("MAILCOW_EXPORTER_CARDINALITY_DENY_DOMAINS")
+	envTopN := intFromEnv("MAILCOW_EXPORTER_CARDINALITY_TOP_N")
+	envCertificateHost, _ := os.LookupEnv("MAILCOW_EXPORTER_CERTIFICATE_HOST")
+	envCertificateServerName, _ := os.LookupEnv("MAILCOW_EXPORTER_CERTIFICATE_SERVER_NAME")
+	envCertificatePorts, _ := os.LookupEnv("MAILCOW_EXPORTER_CERTIFICATE_PORTS")
+	if envCertificatePorts == "" {
+		envCertificatePorts = strings.Join(provider.CertificateDefaultEndpoints, ",")
+	}
+	envTimeout := durationFromEnv("MAILCOW_EXPORTER_TIMEOUT")
+	if envTimeout == 0 {
+		envTimeout = 10 * time.Second
+	}
 
+	flag.StringVar(&configFile, "config", envConfig, "Path to a YAML file defining named targets. Defaults to the MAILCOW_EXPORTER_CONFIG environment variable")
 	flag.StringVar(&defaultHost, "defaultHost", envHost, "The defaultHost to connect to. Defaults to the MAILCOW_EXPORTER_HOST environment variable")
 	flag.StringVar(&defaultApiKey, "apikey", envApiKey, "The API key to use for connection. Defaults to the MAILCOW_EXPORTER_API_KEY environment variable")
 	flag.StringVar(&listen, "listen", defaultListen, "Host and port to listen on. Defaults to the MAILCOW_EXPORTER_LISTEN environment variable or ':9099' otherwise")
+	flag.DurationVar(&interval, "interval", envInterval, "If set, metrics are collected in the background in this interval and scrapes are answered from the last collection. Defaults to the MAILCOW_EXPORTER_INTERVAL environment variable or collecting on every scrape otherwise")
+	flag.DurationVar(&staleness, "staleness", envStaleness, "Maximum age of a background collection before its metrics are no longer served. Defaults to the MAILCOW_EXPORTER_STALENESS environment variable or 3 times the interval otherwise")
+	flag.DurationVar(&timeout, "timeout", envTimeout, "Maximum time providers may take if the scrape timeout is not sent by Prometheus and for background collections. Defaults to the MAILCOW_EXPORTER_TIMEOUT environment variable or '10s' otherwise")
+	flag.DurationVar(&timeoutOffset, "timeoutOffset", 500*time.Millisecond, "Offset to subtract from the scrape timeout sent by Prometheus")
+	flag.DurationVar(&apiOptions.Timeout, "apiTimeout", 30*time.Second, "Maximum time a single request to the mailcow API may take")
+	flag.DurationVar(&apiOptions.KeepAlive, "apiKeepAlive", 30*time.Second, "Interval of TCP keep-alive probes for connections to the mailcow API")
+	flag.DurationVar(&apiOptions.IdleConnTimeout, "apiIdleConnTimeout", 90*time.Second, "Maximum time an idle connection to the mailcow API is kept open for reuse")
+	flag.IntVar(&apiOptions.MaxIdleConns, "apiMaxIdleConns", 10, "Maximum number of idle connections to the mailcow API kept open for reuse per host")
+	flag.IntVar(&apiOptions.Retry.Retries, "apiRetries", 2, "Number of retries of API requests that failed or received a gateway error")
+	flag.DurationVar(&apiOptions.Retry.Backoff, "apiRetryBackoff", 500*time.Millisecond, "Backoff before the first retry of an API request, doubled for every further retry")
+	flag.DurationVar(&apiOptions.Retry.MaxBackoff, "apiRetryMaxBackoff", 5*time.Second, "Maximum backoff between two retries of an API request")
+	flag.IntVar(&apiOptions.Breaker.Threshold, "apiBreakerThreshold", 5, "Number of consecutive failed API requests after which requests to the host are suspended. 0 disables the circuit breaker")
+	flag.DurationVar(&apiOptions.Breaker.Cooldown, "apiBreakerCooldown", time.Minute, "Time requests to a host are suspended after repeated failures")
+	flag.StringVar(&apiOptions.TLS.CAFile, "tlsCaFile", envTLSCAFile, "PEM encoded CA bundle used to verify the certificate of the mailcow API. Defaults to the MAILCOW_EXPORTER_TLS_CA_FILE environment variable or the system roots otherwise")
+	flag.StringVar(&apiOptions.TLS.CertFile, "tlsCertFile", envTLSCertFile, "PEM encoded client certificate for mutual TLS. Defaults to the MAILCOW_EXPORTER_TLS_CERT_FILE environment variable")
+	flag.StringVar(&apiOptions.TLS.KeyFile, "tlsKeyFile", envTLSKeyFile, "PEM encoded key of the client certificate for mutual TLS. Defaults to the MAILCOW_EXPORTER_TLS_KEY_FILE environment variable")
+	flag.StringVar(&apiOptions.TLS.ServerName, "tlsServerName", envTLSServerName, "Server name used to verify the certificate of the mailcow API instead of the host. Defaults to the MAILCOW_EXPORTER_TLS_SERVER_NAME environment variable")
+	flag.BoolVar(&apiOptions.TLS.InsecureSkipVerify, "tlsInsecureSkipVerify", envTLSInsecureSkipVerify, "Disables verification of the certificate of the mailcow API. Defaults to the MAILCOW_EXPORTER_TLS_INSECURE_SKIP_VERIFY environment variable")
+
+	flag.StringVar(&versionProvider.ReleasesFile, "releasesFile", envReleasesFile, "File listing known mailcow release tags, one per line, used to detect available updates. Defaults to the MAILCOW_EXPORTER_RELEASES_FILE environment variable")
+
+	flag.IntVar(&rspamdHistory.TopSymbols, "rspamdTopSymbols", 20, "Number of the most frequent rspamd symbols exported by the rspamd-history provider")
+	flag.StringVar(&cardinality.Aggregation, "cardinalityAggregation", envAggregation, "Level to aggregate per-address series of the mailbox, quarantine and mailq providers to: 'mailbox', 'domain' or 'none'. Defaults to the MAILCOW_EXPORTER_CARDINALITY_AGGREGATION environment variable or 'mailbox' otherwise")
+	flag.IntVar(&cardinality.TopN, "cardinalityTopN", envTopN, "If set, only the N largest mailboxes, recipients or senders are exported individually and all others are summed up as 'other'. Defaults to the MAILCOW_EXPORTER_CARDINALITY_TOP_N environment variable")
+	flag.StringVar(&cardinality.AllowUsers, "cardinalityAllowUsers", envAllowUsers, "Regular expression usernames have to match in order to be exported. Defaults to the MAILCOW_EXPORTER_CARDINALITY_ALLOW_USERS environment variable")
+	flag.StringVar(&cardinality.DenyUsers, "cardinalityDenyUsers", envDenyUsers, "Regular expression of usernames that are not exported. Defaults to the MAILCOW_EXPORTER_CARDINALITY_DENY_USERS environment variable")
+	flag.StringVar(&cardinality.AllowDomains, "cardinalityAllowDomains", envAllowDomains, "Regular expression domains have to match in order to be exported. Defaults to the MAILCOW_EXPORTER_CARDINALITY_ALLOW_DOMAINS environment variable")
+	flag.StringVar(&cardinality.DenyDomains, "cardinalityDenyDomains", envDenyDomains, "Regular expression of domains that are not exported. Defaults to the MAILCOW_EXPORTER_CARDINALITY_DENY_DOMAINS environment variable")
+	flag.StringVar(&certificate.Default.Host, "certificateHost", envCertificateHost, "Host the certificate provider connects to. Defaults to the MAILCOW_EXPORTER_CERTIFICATE_HOST environment variable or the host of the target otherwise")
+	flag.StringVar(&certificate.Default.ServerName, "certificateServerName", envCertificateServerName, "Name the certificates probed by the certificate provider must be valid for. Defaults to the MAILCOW_EXPORTER_CERTIFICATE_SERVER_NAME environment variable or the probed host otherwise")
+	certificatePorts := flag.String("certificatePorts", envCertificatePorts, "Comma separated ports probed by the certificate provider, optionally followed by the protocol used to start TLS, e.g. '2525/smtp'. Defaults to the MAILCOW_EXPORTER_CERTIFICATE_PORTS environment variable or '25,587,465,993,995,443' otherwise")
+
+	enableFlags := make(map[string]*bool)
+	disableFlags := make(map[string]*bool)
+	for _, provider := range providers {
+		enableFlags[provider.Name] = flag.Bool("collector."+provider.Name, !optionalProviders[provider.Name], fmt.Sprintf("Enables the %s provider", provider.Name))
+		disableFlags[provider.Name] = flag.Bool("no-collector."+provider.Name, false, fmt.Sprintf("Disables the %s provider", provider.Name))
+	}
 
 	flag.Parse()
+
+	for _, provider := range providers {
+		collectorEnabled[provider.Name] = *enableFlags[provider.Name] && !*disableFlags[provider.Name]
+	}
+
+	if staleness == 0 {
+		staleness = 3 * interval
+	}
+
+	err := cardinality.Compile()
+	if err != nil {
+		log.Fatalf("Invalid cardinality options: %s", err.Error())
+	}
+
+	// Files are read once on start-up, so that wrong paths are not only noticed by failing scrapes.
+	_, err = apiOptions.TLS.Config()
+	if err != nil {
+		log.Fatalf("Invalid TLS options: %s", err.Error())
+	}
+
+	certificate.Default.Endpoints = strings.Split(*certificatePorts, ",")
+	err = certificate.Default.Validate()
+	if err != nil {
+		log.Fatalf("Invalid certificate options: %s", err.Error())
+	}
 }
 
-func collectMetrics(scheme string, host string, apiKey string) *prometheus.Registry {
-	apiClient := mailcowApi.NewMailcowApiClient(scheme, host, apiKey)
+// Parses the boolean stored in the given environment variable.
+// Returns false if the variable is not set.
+func boolFromEnv(name string) bool {
+	value, _ := os.LookupEnv(name)
+	if value == "" {
+		return false
+	}
 
-	success := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name:        "mailcow_exporter_success",
-		ConstLabels: map[string]string{"host": host},
-	}, []string{"provider"})
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Could not parse %s: %s", name, err.Error())
+	}
 
-	registry := prometheus.NewRegistry()
-	registry.Register(success)
+	return parsed
+}
 
-	for _, provider := range providers {
-		providerSuccess := true
-		collectors, err := provider.Provide(apiClient)
+// Parses the integer stored in the given environment variable.
+// Returns 0 if the variable is not set.
+func intFromEnv(name string) int {
+	value, _ := os.LookupEnv(name)
+	if value == "" {
+		return 0
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Could not parse %s: %s", name, err.Error())
+	}
+
+	return parsed
+}
+
+// Parses the duration stored in the given environment variable.
+// Returns 0 if the variable is not set.
+func durationFromEnv(name string) time.Duration {
+	value, _ := os.LookupEnv(name)
+	if value == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Could not parse %s: %s", name, err.Error())
+	}
+
+	return duration
+}
+
+// Determines how long a scrape may take. Prometheus sends its scrape timeout
+// in the `X-Prometheus-Scrape-Timeout-Seconds` header. A small offset is
+// subtracted from it in order to leave time for the response to be sent.
+func scrapeTimeout(request *http.Request) time.Duration {
+	header := request.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return timeout
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		log.Printf("Could not parse X-Prometheus-Scrape-Timeout-Seconds header `%s`: %s", header, err.Error())
+		return timeout
+	}
+
+	scrapeTimeout := time.Duration(seconds*float64(time.Second)) - timeoutOffset
+	if scrapeTimeout <= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	return scrapeTimeout
+}
+
+func handleMetrics(response http.ResponseWriter, request *http.Request) {
+	t, ok := targetFromRequest(response, request)
+	if !ok {
+		return
+	}
+
+	selected, ok := selectProviders(t, response, request)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), scrapeTimeout(request))
+	defer cancel()
+
+	// Targets that are not collected in the background, e.g. hosts passed as URL parameters, are collected on every scrape.
+	var gatherer prometheus.Gatherer
+	if collection := backgroundCollectionOf(t); collection != nil {
+		gatherer = collection.Gatherer(ctx, selected)
+	} else {
+		metrics, err := collectMetrics(ctx, selected)
 		if err != nil {
-			providerSuccess = false
-			log.Printf(
-				"Error while updating metrics of %T:\n%s",
-				provider,
-				err.Error(),
-			)
+			log.Printf("Could not collect metrics of %s: %s", t.Host, err.Error())
+			response.WriteHeader(http.StatusInternalServerError)
+			response.Write([]byte(err.Error()))
+			return
 		}
+		gatherer = metrics.registry
+	}
 
-		for _, collector := range collectors {
-			err = registry.Register(collector)
-			if err != nil {
-				providerSuccess = false
-				log.Printf(
-					"Error while updating metrics of %T:\n%s",
-					provider,
-					err.Error(),
-				)
-			}
+	promhttp.HandlerFor(
+		gatherer,
+		promhttp.HandlerOpts{},
+	).ServeHTTP(response, request)
+}
+
+// Determines the target of a request. Targets can either be selected by the
+// name they have in the configuration file using the `target` parameter or
+// be passed using the `host`, `apiKey` and `scheme` parameters.
+// If the request is invalid, an error response is written and false is returned.
+func targetFromRequest(response http.ResponseWriter, request *http.Request) (target, bool) {
+	if name := request.URL.Query().Get("target"); name != "" {
+		t, ok := targets[name]
+		if !ok {
+			response.WriteHeader(http.StatusNotFound)
+			response.Write([]byte(fmt.Sprintf("Target `%s` is not defined in the configuration file", name)))
+			return target{}, false
 		}
+		return t, true
+	}
+
+	host := request.URL.Query().Get("host")
+	apiKey := request.URL.Query().Get("apiKey")
+	scheme := request.URL.Query().Get("scheme")
 
-		if providerSuccess {
-			success.WithLabelValues(fmt.Sprintf("%T", provider)).Set(1.0)
-		} else {
-			success.WithLabelValues(fmt.Sprintf("%T", provider)).Set(0.0)
+	if host == "" {
+		host = defaultHost
+	}
+	if apiKey == "" {
+		apiKey = defaultApiKey
+	}
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	if host == "" {
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("Query parameter `host` is required, since it is not defined by flags or environment"))
+		return target{}, false
+	}
+	if apiKey == "" {
+		response.WriteHeader(http.StatusUnauthorized)
+		response.Write([]byte("Query parameter `apiKey` is required, since it is not defined by flags or environment"))
+		return target{}, false
+	}
+
+	// TLS options can only be overridden by targets of the configuration file, so that requests
+	// can neither disable verification for the default API key nor create new HTTP clients.
+	return target{Scheme: scheme, Host: host, ApiKey: apiKey, TLS: apiOptions.TLS}, true
+}
+
+// Restricts the providers of the target to the ones passed in `collect[]` URL parameters, if any.
+// Providers that are disabled for the target or by flags cannot be enabled this way.
+// If the request is invalid, an error response is written and false is returned.
+func selectProviders(t target, response http.ResponseWriter, request *http.Request) (target, bool) {
+	collect, ok := request.URL.Query()["collect[]"]
+	if !ok {
+		return t, true
+	}
+
+	requested := make(map[string]bool)
+	for _, name := range collect {
+		if providerByName(name) == nil {
+			response.WriteHeader(http.StatusBadRequest)
+			response.Write([]byte(fmt.Sprintf("Unknown provider `%s` in `collect[]`", name)))
+			return target{}, false
+		}
+		requested[name] = true
+	}
+
+	selected := make([]string, 0)
+	for _, provider := range t.enabledProviders() {
+		if requested[provider.Name] {
+			selected = append(selected, provider.Name)
 		}
 	}
 
-	for _, collector := range apiClient.Provide() {
-		registry.Register(collector)
+	if len(selected) == 0 {
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write([]byte("None of the providers in `collect[]` are enabled"))
+		return target{}, false
 	}
 
-	return registry
+	t.Providers = strings.Join(selected, ",")
+	return t, true
 }
 
 func main() {
 	parseFlagsAndEnv()
 
-	http.HandleFunc("/metrics", func(response http.ResponseWriter, request *http.Request) {
-		host := request.URL.Query().Get("host")
-		apiKey := request.URL.Query().Get("apiKey")
-		scheme := request.URL.Query().Get("scheme")
-
-		if host == "" {
-			host = defaultHost
-		}
-		if apiKey == "" {
-			apiKey = defaultApiKey
-		}
-		if scheme == "" {
-			scheme = "https"
+	targets = make(map[string]target)
+	if configFile != "" {
+		var err error
+		targets, err = loadConfig(configFile)
+		if err != nil {
+			log.Fatal(err.Error())
 		}
+	}
 
-		if host == "" {
-			response.WriteHeader(http.StatusBadRequest)
-			response.Write([]byte("Query parameter `host` is required, since it is not defined by flags or environment"))
-			return
+	// Lifetime metrics and state are only kept for hosts known on start-up.
+	if defaultHost != "" {
+		mailcowApi.KeepState(defaultHost)
+	}
+	for _, t := range targets {
+		mailcowApi.KeepState(t.Host)
+	}
+
+	if interval > 0 {
+		if defaultHost != "" && defaultApiKey != "" {
+			startBackgroundCollection(target{Scheme: "https", Host: defaultHost, ApiKey: defaultApiKey, TLS: apiOptions.TLS})
 		}
-		if apiKey == "" {
-			response.WriteHeader(http.StatusUnauthorized)
-			response.Write([]byte("Query parameter `apiKey` is required, since it is not defined by flags or environment"))
-			return
+		for _, t := range targets {
+			startBackgroundCollection(t)
 		}
+	}
 
-		registry := collectMetrics(scheme, host, apiKey)
-
-		promhttp.HandlerFor(
-			registry,
-			promhttp.HandlerOpts{},
-		).ServeHTTP(response, request)
-	})
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/probe", handleMetrics)
 
 	log.Printf("Starting to listen on %s", listen)
 	log.Fatal(http.ListenAndServe(listen, nil))
@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ACME provider. This provider uses the `/api/v1/get/logs/acme/<n>` endpoint
+// in order to export the status of the certificate renewal. Since older entries
+// drop out of the log, the last known values are kept per host, hence this
+// provider must be used as a pointer.
+type Acme struct {
+	mutex sync.Mutex
+	hosts map[string]*acmeState
+}
+
+// Last known renewal status of a host
+type acmeState struct {
+	lastSuccess   float64
+	lastFailure   float64
+	failureReason string
+	lastCheck     float64
+	namesTime     float64
+	names         []string
+}
+
+var (
+	acmeSuccessPattern = regexp.MustCompile(`(?i)certificate successfully (?:obtained|requested|renewed|deployed)`)
+	acmeFailurePattern = regexp.MustCompile(`(?i)(?:failed to obtain|cannot validate|error|retrying in)`)
+	acmeCheckPattern   = regexp.MustCompile(`(?i)neither changed nor due for renewal`)
+	// Names the certificate is requested for, e.g. `for domains 'mail.example.com autodiscover.example.com'`
+	acmeDomainsPattern = regexp.MustCompile(`(?i)for domains? '([^']+)'`)
+	acmeSanPattern     = regexp.MustCompile(`(?i)\b(?:CN|SANs?):? ((?:[a-z0-9*-]+\.)+[a-z]{2,}(?:[ ,]+(?:[a-z0-9*-]+\.)+[a-z]{2,})*)`)
+)
+
+// Maximum number of characters of the failure reason, which is taken from the log message
+const acmeReasonLength = 200
+
+// Returns the names contained in the message, if any.
+func acmeNames(message string) []string {
+	match := acmeDomainsPattern.FindStringSubmatch(message)
+	if match == nil {
+		match = acmeSanPattern.FindStringSubmatch(message)
+	}
+	if match == nil {
+		return nil
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.FieldsFunc(match[1], func(r rune) bool { return r == ' ' || r == ',' }) {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (state *acmeState) update(entries []logEntry) {
+	for _, entry := range entries {
+		t, err := entry.Time.Float64()
+		if err != nil {
+			continue
+		}
+
+		failed := false
+		switch {
+		case acmeSuccessPattern.MatchString(entry.Message):
+			if t > state.lastSuccess {
+				state.lastSuccess = t
+			}
+		case acmeCheckPattern.MatchString(entry.Message):
+			if t > state.lastCheck {
+				state.lastCheck = t
+			}
+		case acmeFailurePattern.MatchString(entry.Message):
+			failed = true
+			if t > state.lastFailure {
+				state.lastFailure = t
+				reason := []rune(strings.TrimSpace(entry.Message))
+				if len(reason) > acmeReasonLength {
+					reason = reason[:acmeReasonLength]
+				}
+				state.failureReason = string(reason)
+			}
+		}
+
+		// The names of failed requests are not covered by the certificate.
+		if names := acmeNames(entry.Message); names != nil && !failed && t >= state.namesTime {
+			state.namesTime = t
+			state.names = names
+		}
+	}
+}
+
+func (acme *Acme) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	lastSuccess := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_acme_last_success_timestamp",
+		Help:        "Unix timestamp of the last successful certificate renewal",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{})
+	lastFailure := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_acme_last_failure_timestamp",
+		Help:        "Unix timestamp of the last failed certificate renewal. `reason` contains the log message of the failure",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"reason"})
+	lastCheck := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_acme_last_check_timestamp",
+		Help:        "Unix timestamp of the last check that found the certificate neither changed nor due for renewal",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{})
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_acme_certificate_info",
+		Help:        "Names the certificate is requested for, separated by commas. The value is always 1",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"names"})
+	collectors := []prometheus.Collector{lastSuccess, lastFailure, lastCheck, info}
+
+	body := make([]logEntry, 0)
+	err := api.Get(ctx, fmt.Sprintf("api/v1/get/logs/acme/%d", logEntries), &body)
+
+	acme.mutex.Lock()
+	defer acme.mutex.Unlock()
+
+	if acme.hosts == nil {
+		acme.hosts = make(map[string]*acmeState)
+	}
+	state, ok := acme.hosts[api.Host]
+	if !ok {
+		state = &acmeState{}
+		// Hosts whose state is not kept only report what the current log contains.
+		if api.KeepsState() {
+			acme.hosts[api.Host] = state
+		}
+	}
+	if err == nil {
+		state.update(body)
+	}
+
+	// Values that were never logged are not reported.
+	if state.lastSuccess > 0 {
+		lastSuccess.WithLabelValues().Set(state.lastSuccess)
+	}
+	if state.lastFailure > 0 {
+		lastFailure.WithLabelValues(state.failureReason).Set(state.lastFailure)
+	}
+	if state.lastCheck > 0 {
+		lastCheck.WithLabelValues().Set(state.lastCheck)
+	}
+	if state.names != nil {
+		info.WithLabelValues(strings.Join(state.names, ",")).Set(1.0)
+	}
+
+	return collectors, err
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Alias Provider. This provider uses the `/api/v1/get/alias/all`
+// endpoint in order to gather metrics.
+type Alias struct{}
+
+type aliasItem struct {
+	Address     string      `json:"address"`
+	Domain      string      `json:"domain"`
+	Goto        string      `json:"goto"`
+	Active      json.Number `json:"active"`
+	SogoVisible json.Number `json:"sogo_visible"`
+	Created     string      `json:"created"`
+	Modified    string      `json:"modified"`
+}
+
+// Format of the `created` and `modified` dates returned by the API
+const aliasDateFormat = "2006-01-02 15:04:05"
+
+// All alias gauges have the same options anyways.
+func aliasGauge(name string, description string, host string) prometheus.GaugeVec {
+	return *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        description,
+		ConstLabels: map[string]string{"host": host},
+	}, []string{"alias", "domain"})
+}
+
+func (alias Alias) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	active := aliasGauge("mailcow_alias_active", "Active flag for this alias", api.Host)
+	gotoCount := aliasGauge("mailcow_alias_goto_count", "Number of targets the alias forwards to", api.Host)
+	catchAll := aliasGauge("mailcow_alias_catch_all", "1 if the alias is a catch-all alias for the whole domain, 0 if not", api.Host)
+	sogoVisible := aliasGauge("mailcow_alias_sogo_visible", "1 if the alias is visible in SOGo, 0 if not", api.Host)
+	created := aliasGauge("mailcow_alias_created", "Unix timestamp of the alias creation", api.Host)
+	modified := aliasGauge("mailcow_alias_modified", "Unix timestamp of the last alias modification", api.Host)
+	collectors := []prometheus.Collector{active, gotoCount, catchAll, sogoVisible, created, modified}
+
+	body := make([]aliasItem, 0)
+	err := api.Get(ctx, "api/v1/get/alias/all", &body)
+	if err != nil {
+		return collectors, err
+	}
+
+	for _, a := range body {
+		valueActive, err := a.Active.Float64()
+		if err != nil {
+			return collectors, err
+		}
+
+		valueGotoCount := 0.0
+		for _, target := range strings.Split(a.Goto, ",") {
+			if strings.TrimSpace(target) != "" {
+				valueGotoCount++
+			}
+		}
+
+		// Catch-all aliases are stored as `@domain` without a local part.
+		valueCatchAll := 0.0
+		if strings.HasPrefix(a.Address, "@") {
+			valueCatchAll = 1.0
+		}
+
+		active.WithLabelValues(a.Address, a.Domain).Set(valueActive)
+		gotoCount.WithLabelValues(a.Address, a.Domain).Set(valueGotoCount)
+		catchAll.WithLabelValues(a.Address, a.Domain).Set(valueCatchAll)
+
+		// `sogo_visible` is not returned by older mailcow versions.
+		if a.SogoVisible != "" {
+			valueSogoVisible, err := a.SogoVisible.Float64()
+			if err != nil {
+				return collectors, err
+			}
+			sogoVisible.WithLabelValues(a.Address, a.Domain).Set(valueSogoVisible)
+		}
+
+		if a.Created != "" {
+			t, err := time.Parse(aliasDateFormat, a.Created)
+			if err != nil {
+				return collectors, err
+			}
+			created.WithLabelValues(a.Address, a.Domain).Set(float64(t.Unix()))
+		}
+
+		// `modified` is null for aliases that have never been changed.
+		if a.Modified != "" {
+			t, err := time.Parse(aliasDateFormat, a.Modified)
+			if err != nil {
+				return collectors, err
+			}
+			modified.WithLabelValues(a.Address, a.Domain).Set(float64(t.Unix()))
+		}
+	}
+
+	return collectors, nil
+}
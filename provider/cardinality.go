@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Aggregation levels of per-address series
+const (
+	AggregateMailbox = "mailbox"
+	AggregateDomain  = "domain"
+	AggregateNone    = "none"
+)
+
+// Label value of the addresses that are not among the top N.
+const otherLabel = "other"
+
+// Cardinality controls the number of series created by providers that
+// export one series per address (e.g. mailboxes, quarantine recipients
+// or queue senders). Call `Compile` once all options are set.
+type Cardinality struct {
+	// One of `mailbox`, `domain` or `none`. Defaults to `mailbox`.
+	Aggregation string
+	// If greater than 0, only the N largest series are emitted individually
+	// and all others are summed up as `other`.
+	TopN int
+
+	// Regular expressions for usernames (complete addresses) and domains.
+	// Addresses not matching the allow or matching the deny expressions are not exported.
+	AllowUsers   string
+	DenyUsers    string
+	AllowDomains string
+	DenyDomains  string
+
+	allowUsers   *regexp.Regexp
+	denyUsers    *regexp.Regexp
+	allowDomains *regexp.Regexp
+	denyDomains  *regexp.Regexp
+}
+
+// Validates the options and compiles the regular expressions.
+// The expressions are anchored and have to match the whole username or domain.
+func (cardinality *Cardinality) Compile() error {
+	switch cardinality.Aggregation {
+	case "":
+		cardinality.Aggregation = AggregateMailbox
+	case AggregateMailbox, AggregateDomain, AggregateNone:
+	default:
+		return fmt.Errorf("Unknown aggregation `%s`, must be one of mailbox, domain or none", cardinality.Aggregation)
+	}
+
+	if cardinality.TopN < 0 {
+		return fmt.Errorf("Top N must not be negative")
+	}
+
+	expressions := []struct {
+		source string
+		target **regexp.Regexp
+	}{
+		{cardinality.AllowUsers, &cardinality.allowUsers},
+		{cardinality.DenyUsers, &cardinality.denyUsers},
+		{cardinality.AllowDomains, &cardinality.allowDomains},
+		{cardinality.DenyDomains, &cardinality.denyDomains},
+	}
+	for _, expression := range expressions {
+		if expression.source == "" {
+			continue
+		}
+
+		compiled, err := regexp.Compile("^(?:" + expression.source + ")$")
+		if err != nil {
+			return fmt.Errorf("Invalid expression `%s`: %s", expression.source, err.Error())
+		}
+		*expression.target = compiled
+	}
+
+	return nil
+}
+
+// Returns the domain part of an address or an empty string if it has none.
+func addressDomain(address string) string {
+	separator := strings.LastIndex(address, "@")
+	if separator == -1 {
+		return ""
+	}
+
+	return strings.ToLower(address[separator+1:])
+}
+
+// Returns false if the address is excluded by the allow and deny expressions.
+// A nil Cardinality allows all addresses.
+func (cardinality *Cardinality) allowed(address string) bool {
+	if cardinality == nil {
+		return true
+	}
+
+	domain := addressDomain(address)
+	if cardinality.allowUsers != nil && !cardinality.allowUsers.MatchString(address) {
+		return false
+	}
+	if cardinality.denyUsers != nil && cardinality.denyUsers.MatchString(address) {
+		return false
+	}
+	if cardinality.allowDomains != nil && !cardinality.allowDomains.MatchString(domain) {
+		return false
+	}
+	if cardinality.denyDomains != nil && cardinality.denyDomains.MatchString(domain) {
+		return false
+	}
+
+	return true
+}
+
+// Returns the label value of the address according to the aggregation level:
+// The address itself, its domain or an empty string.
+func (cardinality *Cardinality) key(address string) string {
+	if cardinality == nil {
+		return address
+	}
+
+	switch cardinality.Aggregation {
+	case AggregateDomain:
+		return addressDomain(address)
+	case AggregateNone:
+		return ""
+	}
+
+	return address
+}
+
+// Returns the label value of the domain of the address according to the aggregation level:
+// The domain for `mailbox` and `domain`, an empty string for `none`.
+func (cardinality *Cardinality) domainKey(address string) string {
+	if cardinality != nil && cardinality.Aggregation == AggregateNone {
+		return ""
+	}
+
+	return addressDomain(address)
+}
+
+// Returns true if the address label is the domain of the address
+// or the address itself, but not a summary of several domains.
+func (cardinality *Cardinality) keepsDomain(label string) bool {
+	if cardinality == nil {
+		return true
+	}
+
+	return label != otherLabel && cardinality.Aggregation != AggregateNone
+}
+
+// Maps the given keys to their final label values. If top N is enabled,
+// only the N keys with the largest weights keep their value, all others are mapped to `other`.
+func (cardinality *Cardinality) labels(weights map[string]float64) map[string]string {
+	labels := make(map[string]string, len(weights))
+	keys := make([]string, 0, len(weights))
+	for key := range weights {
+		labels[key] = key
+		keys = append(keys, key)
+	}
+
+	if cardinality == nil || cardinality.TopN == 0 || len(keys) <= cardinality.TopN {
+		return labels
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if weights[keys[i]] != weights[keys[j]] {
+			return weights[keys[i]] > weights[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys[cardinality.TopN:] {
+		labels[key] = otherLabel
+	}
+
+	return labels
+}
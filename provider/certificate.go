@@ -0,0 +1,330 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Endpoints probed by default: SMTP and submission using STARTTLS,
+// submissions, IMAPS, POP3S and HTTPS using implicit TLS.
+var CertificateDefaultEndpoints = []string{"25", "587", "465", "993", "995", "443"}
+
+// Certificate provider. In contrast to all other providers, this provider does not use
+// the mailcow API, but connects to the mail and web ports of the host in order to
+// export the certificates that are presented to clients.
+type Certificate struct {
+	// Options used for hosts without own options
+	Default CertificateOptions
+	// Options per API host, e.g. set by the configuration file
+	Targets map[string]CertificateOptions
+}
+
+// CertificateOptions define where certificates are probed.
+type CertificateOptions struct {
+	// Host to connect to. Defaults to the host of the API without port.
+	Host string
+	// Name the certificate must be valid for. Defaults to the host.
+	ServerName string
+	// Ports to probe, optionally followed by the protocol used to start TLS, e.g. `2525/smtp`.
+	// The protocol is one of `tls` (implicit TLS), `smtp`, `imap` or `pop3` (STARTTLS).
+	// Without protocol, 25 and 587 use `smtp`, 143 uses `imap`, 110 uses `pop3` and all others `tls`.
+	Endpoints []string
+}
+
+// Error of a single probe
+type ProbeError struct {
+	Endpoint string
+	Err      error
+}
+
+func (err *ProbeError) Error() string {
+	return fmt.Sprintf("Could not probe certificate of %s: %s", err.Endpoint, err.Err.Error())
+}
+
+func (err *ProbeError) Unwrap() error {
+	return err.Err
+}
+
+type certificateEndpoint struct {
+	port     string
+	protocol string
+}
+
+// Result of a single probe
+type certificateProbe struct {
+	certificate *x509.Certificate
+	version     uint16
+}
+
+// Names of the TLS versions, since `tls.VersionName` is not available in all supported go versions
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func parseCertificateEndpoint(spec string) (certificateEndpoint, error) {
+	parts := strings.SplitN(strings.TrimSpace(spec), "/", 2)
+	port, err := strconv.Atoi(parts[0])
+	if err != nil || port < 1 || port > 65535 {
+		return certificateEndpoint{}, fmt.Errorf("Invalid port in `%s`", spec)
+	}
+
+	endpoint := certificateEndpoint{port: parts[0]}
+	if len(parts) == 2 {
+		endpoint.protocol = parts[1]
+	} else {
+		switch port {
+		case 25, 587:
+			endpoint.protocol = "smtp"
+		case 143:
+			endpoint.protocol = "imap"
+		case 110:
+			endpoint.protocol = "pop3"
+		default:
+			endpoint.protocol = "tls"
+		}
+	}
+
+	switch endpoint.protocol {
+	case "tls", "smtp", "imap", "pop3":
+		return endpoint, nil
+	}
+
+	return certificateEndpoint{}, fmt.Errorf("Unknown protocol in `%s`, must be one of tls, smtp, imap or pop3", spec)
+}
+
+// Validates the endpoints of the options.
+func (options CertificateOptions) Validate() error {
+	for _, spec := range options.Endpoints {
+		if _, err := parseCertificateEndpoint(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the host without port, e.g. `mail.example.com` for `mail.example.com:8443`.
+func hostWithoutPort(host string) string {
+	withoutPort, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return strings.Trim(host, "[]")
+	}
+
+	return withoutPort
+}
+
+// Asks the server to start TLS using the given protocol. Multi-line SMTP responses are read by textproto.
+func startTLS(conn net.Conn, protocol string) error {
+	text := textproto.NewConn(conn)
+
+	switch protocol {
+	case "smtp":
+		if _, _, err := text.ReadResponse(220); err != nil {
+			return err
+		}
+		if err := text.PrintfLine("EHLO mailcow-exporter"); err != nil {
+			return err
+		}
+		if _, _, err := text.ReadResponse(250); err != nil {
+			return err
+		}
+		if err := text.PrintfLine("STARTTLS"); err != nil {
+			return err
+		}
+		_, _, err := text.ReadResponse(220)
+		return err
+
+	case "imap":
+		if _, err := expectLine(text, "* OK"); err != nil {
+			return err
+		}
+		if err := text.PrintfLine("a1 STARTTLS"); err != nil {
+			return err
+		}
+		// Untagged responses may precede the tagged one.
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a1 ") {
+				if !strings.HasPrefix(line, "a1 OK") {
+					return fmt.Errorf("STARTTLS failed: %s", line)
+				}
+				return nil
+			}
+		}
+
+	case "pop3":
+		if _, err := expectLine(text, "+OK"); err != nil {
+			return err
+		}
+		if err := text.PrintfLine("STLS"); err != nil {
+			return err
+		}
+		_, err := expectLine(text, "+OK")
+		return err
+	}
+
+	return nil
+}
+
+// Reads a line and makes sure it starts with the given prefix.
+func expectLine(text *textproto.Conn, prefix string) (string, error) {
+	line, err := text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return line, fmt.Errorf("Unexpected response `%s`", line)
+	}
+
+	return line, nil
+}
+
+// Connects to the endpoint and returns the leaf certificate and the negotiated TLS version.
+func (certificate Certificate) probe(ctx context.Context, host string, serverName string, endpoint certificateEndpoint) (certificateProbe, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, endpoint.port))
+	if err != nil {
+		return certificateProbe{}, err
+	}
+	defer conn.Close()
+
+	// The context only limits connecting, the deadline limits the whole conversation.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	if endpoint.protocol != "tls" {
+		if err := startTLS(conn, endpoint.protocol); err != nil {
+			return certificateProbe{}, fmt.Errorf("Could not start TLS using %s: %s", endpoint.protocol, err.Error())
+		}
+	}
+
+	// The certificate is checked below, so that invalid certificates are reported instead of failing the probe.
+	client := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err := client.Handshake(); err != nil {
+		return certificateProbe{}, err
+	}
+
+	state := client.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return certificateProbe{}, fmt.Errorf("No certificate presented")
+	}
+
+	return certificateProbe{certificate: state.PeerCertificates[0], version: state.Version}, nil
+}
+
+func (certificate Certificate) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	success := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_certificate_probe_success",
+		Help:        "1 if the certificate of the port could be retrieved, 0 if not",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"port"})
+	notAfter := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_certificate_not_after_timestamp",
+		Help:        "Unix timestamp after which the certificate presented on the port expires",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"port"})
+	issuer := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_certificate_issuer_info",
+		Help:        "Issuer of the certificate presented on the port, the value is always 1",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"port", "issuer"})
+	nameMatch := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_certificate_name_match",
+		Help:        "1 if the certificate presented on the port is valid for the server name, 0 if not",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"port", "server_name"})
+	version := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_certificate_tls_version_info",
+		Help:        "TLS version negotiated on the port, the value is always 1",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"port", "version"})
+	collectors := []prometheus.Collector{success, notAfter, issuer, nameMatch, version}
+
+	options, ok := certificate.Targets[api.Host]
+	if !ok {
+		options = certificate.Default
+	}
+	host := options.Host
+	if host == "" {
+		host = hostWithoutPort(api.Host)
+	}
+	serverName := options.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	specs := options.Endpoints
+	if len(specs) == 0 {
+		specs = CertificateDefaultEndpoints
+	}
+
+	endpoints := make([]certificateEndpoint, len(specs))
+	for i, spec := range specs {
+		endpoint, err := parseCertificateEndpoint(spec)
+		if err != nil {
+			return collectors, err
+		}
+		endpoints[i] = endpoint
+	}
+
+	results := make([]certificateProbe, len(endpoints))
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint certificateEndpoint) {
+			defer wg.Done()
+			results[i], errs[i] = certificate.probe(ctx, host, serverName, endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	var lastErr error
+	for i, endpoint := range endpoints {
+		if errs[i] != nil {
+			success.WithLabelValues(endpoint.port).Set(0.0)
+			lastErr = &ProbeError{Endpoint: net.JoinHostPort(host, endpoint.port), Err: errs[i]}
+			continue
+		}
+
+		leaf := results[i].certificate
+		versionName, ok := tlsVersionNames[results[i].version]
+		if !ok {
+			versionName = fmt.Sprintf("0x%04x", results[i].version)
+		}
+		matches := 0.0
+		if leaf.VerifyHostname(serverName) == nil {
+			matches = 1.0
+		}
+
+		success.WithLabelValues(endpoint.port).Set(1.0)
+		notAfter.WithLabelValues(endpoint.port).Set(float64(leaf.NotAfter.Unix()))
+		issuer.WithLabelValues(endpoint.port, leaf.Issuer.String()).Set(1.0)
+		nameMatch.WithLabelValues(endpoint.port, serverName).Set(matches)
+		version.WithLabelValues(endpoint.port, versionName).Set(1.0)
+	}
+
+	return collectors, lastErr
+}
@@ -0,0 +1,220 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Starts a HTTPS listener using the certificate of `httptest`, which is valid for `example.com` and 127.0.0.1.
+func startTLSListener(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// Starts a SMTP listener that supports STARTTLS using the given certificate and returns its port.
+func startSmtpListener(t *testing.T, certificate tls.Certificate) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				conn.Write([]byte("220-mail.example.com ESMTP\r\n220 ready\r\n"))
+				if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "EHLO ") {
+					return
+				}
+				conn.Write([]byte("250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n"))
+				if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "STARTTLS") {
+					return
+				}
+				conn.Write([]byte("220 2.0.0 Ready to start TLS\r\n"))
+
+				server := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{certificate}})
+				server.Handshake()
+			}(conn)
+		}
+	}()
+
+	return listenerPort(t, listener.Addr().String())
+}
+
+// Returns a port nothing is listening on.
+func refusedPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listenerPort(t, listener.Addr().String())
+	listener.Close()
+
+	return port
+}
+
+func listenerPort(t *testing.T, address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return port
+}
+
+// Returns the value of the metric with the given name and labels, and false if there is none.
+func metricValue(t *testing.T, collectors []prometheus.Collector, name string, labels map[string]string) (float64, bool) {
+	registry := prometheus.NewRegistry()
+	for _, collector := range collectors {
+		registry.MustRegister(collector)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.Metric {
+			matches := 0
+			for _, label := range metric.Label {
+				if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+					matches++
+				}
+			}
+			if matches == len(labels) {
+				return metric.GetGauge().GetValue(), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func provideCertificates(t *testing.T, options CertificateOptions) ([]prometheus.Collector, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	certificate := Certificate{Default: options}
+	return certificate.Provide(ctx, mailcowApi.MailcowApiClient{Host: "mail.example.com"})
+}
+
+func TestCertificateImplicitTLS(t *testing.T) {
+	server := startTLSListener(t)
+	port := listenerPort(t, server.Listener.Addr().String())
+
+	collectors, err := provideCertificates(t, CertificateOptions{
+		Host:       "127.0.0.1",
+		ServerName: "example.com",
+		Endpoints:  []string{port},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	leaf := server.Certificate()
+	if value, _ := metricValue(t, collectors, "mailcow_certificate_probe_success", map[string]string{"port": port}); value != 1 {
+		t.Errorf("Expected probe_success 1, got %v", value)
+	}
+	if value, _ := metricValue(t, collectors, "mailcow_certificate_not_after_timestamp", map[string]string{"port": port}); value != float64(leaf.NotAfter.Unix()) {
+		t.Errorf("Expected not_after %d, got %v", leaf.NotAfter.Unix(), value)
+	}
+	if _, ok := metricValue(t, collectors, "mailcow_certificate_issuer_info", map[string]string{"port": port, "issuer": leaf.Issuer.String()}); !ok {
+		t.Errorf("Expected issuer_info with issuer `%s`", leaf.Issuer.String())
+	}
+	if _, ok := metricValue(t, collectors, "mailcow_certificate_tls_version_info", map[string]string{"port": port, "version": "TLS 1.3"}); !ok {
+		t.Errorf("Expected tls_version_info with version `TLS 1.3`")
+	}
+}
+
+func TestCertificateSmtpStartTLS(t *testing.T) {
+	server := startTLSListener(t)
+	port := startSmtpListener(t, server.TLS.Certificates[0])
+
+	collectors, err := provideCertificates(t, CertificateOptions{
+		Host:       "127.0.0.1",
+		ServerName: "example.com",
+		Endpoints:  []string{port + "/smtp"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if value, _ := metricValue(t, collectors, "mailcow_certificate_probe_success", map[string]string{"port": port}); value != 1 {
+		t.Errorf("Expected probe_success 1, got %v", value)
+	}
+	if value, _ := metricValue(t, collectors, "mailcow_certificate_not_after_timestamp", map[string]string{"port": port}); value != float64(server.Certificate().NotAfter.Unix()) {
+		t.Errorf("Expected not_after %d, got %v", server.Certificate().NotAfter.Unix(), value)
+	}
+}
+
+func TestCertificateRefusedPort(t *testing.T) {
+	port := refusedPort(t)
+
+	collectors, err := provideCertificates(t, CertificateOptions{
+		Host:      "127.0.0.1",
+		Endpoints: []string{port},
+	})
+
+	var probeError *ProbeError
+	if !errors.As(err, &probeError) {
+		t.Fatalf("Expected a ProbeError, got %v", err)
+	}
+	if value, ok := metricValue(t, collectors, "mailcow_certificate_probe_success", map[string]string{"port": port}); !ok || value != 0 {
+		t.Errorf("Expected probe_success 0, got %v", value)
+	}
+	if _, ok := metricValue(t, collectors, "mailcow_certificate_not_after_timestamp", map[string]string{"port": port}); ok {
+		t.Errorf("Expected no not_after for a refused port")
+	}
+}
+
+func TestCertificateNameMatch(t *testing.T) {
+	server := startTLSListener(t)
+	port := listenerPort(t, server.Listener.Addr().String())
+
+	tests := []struct {
+		serverName string
+		expected   float64
+	}{
+		{"example.com", 1},
+		{"mail.example.org", 0},
+	}
+	for _, test := range tests {
+		collectors, err := provideCertificates(t, CertificateOptions{
+			Host:       "127.0.0.1",
+			ServerName: test.serverName,
+			Endpoints:  []string{port},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+
+		value, ok := metricValue(t, collectors, "mailcow_certificate_name_match", map[string]string{"port": port, "server_name": test.serverName})
+		if !ok || value != test.expected {
+			t.Errorf("Expected name_match %v for `%s`, got %v", test.expected, test.serverName, value)
+		}
+	}
+}
@@ -1,13 +1,25 @@
 package provider
 
 import (
+	"context"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/j6s/mailcow-exporter/mailcowApi"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-type Container struct{}
+// Container provider. This provider uses the `/api/v1/get/status/containers`
+// endpoint in order to gather metrics. Restarts are derived from changes of the
+// start time between collections, hence this provider must be used as a pointer.
+// The health check status of containers is not returned by the API and therefore not exported.
+type Container struct {
+	mutex sync.Mutex
+	// Known containers, indexed by host and container name
+	known map[string]map[string]*containerState
+}
 
 type containerItem struct {
 	Container string `json:"container"`
@@ -16,7 +28,55 @@ type containerItem struct {
 	Image     string `json:"image"`
 }
 
-func (container Container) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+type containerState struct {
+	startedAt time.Time
+	restarts  int
+}
+
+// Records the start time of the container and returns the number of restarts
+// observed since the container was first seen. Restarts are not observed for
+// hosts whose state is not kept (see `mailcowApi.KeepsState`).
+func (container *Container) restarts(host string, name string, startedAt time.Time) int {
+	if !mailcowApi.KeepsState(host) {
+		return 0
+	}
+
+	container.mutex.Lock()
+	defer container.mutex.Unlock()
+
+	if container.known == nil {
+		container.known = make(map[string]map[string]*containerState)
+	}
+	if container.known[host] == nil {
+		container.known[host] = make(map[string]*containerState)
+	}
+
+	state, ok := container.known[host][name]
+	if !ok {
+		state = &containerState{startedAt: startedAt}
+		container.known[host][name] = state
+	}
+
+	if startedAt.After(state.startedAt) {
+		state.startedAt = startedAt
+		state.restarts++
+	}
+
+	return state.restarts
+}
+
+// Splits the tag off an image reference such as `mailcow/dovecot:1.130`.
+// Images without a tag are reported as `latest`.
+func imageTag(image string) string {
+	separator := strings.LastIndex(image, ":")
+	if separator == -1 || strings.Contains(image[separator:], "/") {
+		return "latest"
+	}
+
+	return image[separator+1:]
+}
+
+func (container *Container) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
 	startTime := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        "mailcow_container_start",
 		Help:        "Unix timestamp of the container start",
@@ -27,10 +87,25 @@ func (container Container) Provide(api mailcowApi.MailcowApiClient) ([]prometheu
 		Help:        "1 if the container is running, 0 if not",
 		ConstLabels: map[string]string{"host": api.Host},
 	}, []string{"container", "image"})
-	collectors := []prometheus.Collector{running, startTime}
+	state := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_container_state",
+		Help:        "State of the container (e.g. running, restarting, exited), the value is always 1",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"container", "state"})
+	restarts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_container_restarts_total",
+		Help:        "Number of restarts of the container observed by the exporter, derived from changes of the start time",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"container"})
+	imageInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_container_image_info",
+		Help:        "Image and image tag the container is running, the value is always 1",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"container", "image", "tag"})
+	collectors := []prometheus.Collector{running, startTime, state, restarts, imageInfo}
 
 	body := make(map[string]containerItem)
-	err := api.Get("api/v1/get/status/containers", &body)
+	err := api.Get(ctx, "api/v1/get/status/containers", &body)
 	if err != nil {
 		return collectors, err
 	}
@@ -41,13 +116,19 @@ func (container Container) Provide(api mailcowApi.MailcowApiClient) ([]prometheu
 			isRunning = 1.0
 		}
 
+		running.WithLabelValues(item.Container, item.Image).Set(isRunning)
+		state.WithLabelValues(item.Container, item.State).Set(1.0)
+		imageInfo.WithLabelValues(item.Container, item.Image, imageTag(item.Image)).Set(1.0)
+
+		// A single container with an invalid start time should not hide all other containers.
 		t, err := time.Parse(time.RFC3339Nano, item.StartedAt)
 		if err != nil {
-			return []prometheus.Collector{}, err
+			log.Printf("Could not parse start time of container %s: %s", item.Container, err.Error())
+			continue
 		}
 
-		running.WithLabelValues(item.Container, item.Image).Set(isRunning)
 		startTime.WithLabelValues(item.Container, item.Image).Set(float64(t.Unix()))
+		restarts.WithLabelValues(item.Container).Add(float64(container.restarts(api.Host, item.Container, t)))
 	}
 
 	return collectors, nil
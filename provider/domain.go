@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/j6s/mailcow-exporter/mailcowApi"
@@ -32,7 +33,7 @@ func domainGauge(name string, description string, host string) prometheus.GaugeV
 	}, []string{"domain"})
 }
 
-func (domain Domain) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+func (domain Domain) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
 	active := domainGauge("mailcow_domain_active", "Active flag for this domain", api.Host)
 	mailboxes := domainGauge("mailcow_domain_mailboxes", "Current mailboxes count for the domain", api.Host)
 	maxMailboxes := domainGauge("mailcow_domain_max_mailboxes", "Maximum amount of mailboxes for the domain", api.Host)
@@ -44,7 +45,7 @@ func (domain Domain) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Coll
 	collectors := []prometheus.Collector{active, mailboxes, maxMailboxes, aliases, maxAliases, quotaAllowed, quotaUsed, messages}
 
 	body := make([]domainItem, 0)
-	err := api.Get("api/v1/get/domain/all", &body)
+	err := api.Get(ctx, "api/v1/get/domain/all", &body)
 	if err != nil {
 		return collectors, err
 	}
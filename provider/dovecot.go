@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Dovecot provider. This provider uses the `/api/v1/get/logs/dovecot/<n>`
+// endpoint in order to count logins, authentication failures, disconnects and
+// deliveries that failed because of the quota. Only entries logged since the previous
+// collection are counted, hence this provider must be used as a pointer.
+type Dovecot struct {
+	counters logCounters
+}
+
+var (
+	// Matches the process of a line, e.g. `imap-login: `, `auth-worker(123): ` or `lmtp(foo@bar.com)<123><abc>: `
+	dovecotProcessPattern = regexp.MustCompile(`^(imap|pop3|lmtp|managesieve|sieve|submission|auth)(-login|-worker)?(?:\([^)]*\))?(?:<[^>]*>)*: `)
+	// Matches the reason of login process disconnects, e.g. `Disconnected (auth failed, 1 attempts in 2 secs)`
+	// or `Disconnected: Inactivity (no auth attempts in 180 secs)`. The text and the part in parentheses are optional.
+	dovecotLoginReasonPattern    = regexp.MustCompile(`^(?:Disconnected|Aborted login|Login aborted):? ?([^(]*)(?:\(([^)]*)\))?`)
+	dovecotMailDisconnectPattern = regexp.MustCompile(`^Disconnected:? (.*)`)
+)
+
+// A reason of which any of the parts must be contained in a log message
+type dovecotReasonClass struct {
+	reason   string
+	contains []string
+}
+
+// Reasons of authentication failures of the login processes and the auth process, checked in order.
+var dovecotAuthFailureReasons = []dovecotReasonClass{
+	{"password_mismatch", []string{"password mismatch"}},
+	{"unknown_user", []string{"unknown user"}},
+	{"disallowed_plaintext", []string{"disallowed plaintext auth"}},
+	{"unsupported_mechanism", []string{"unsupported auth mechanism"}},
+	{"aborted", []string{"didn't finish sasl auth"}},
+	{"internal", []string{"internal failure"}},
+	{"auth_failed", []string{"auth failed"}},
+}
+
+// Reasons of disconnects, checked in order.
+var dovecotDisconnectReasons = []dovecotReasonClass{
+	{"logged_out", []string{"logged out"}},
+	{"connection_closed", []string{"connection closed"}},
+	{"inactivity", []string{"inactivity"}},
+	{"shutdown", []string{"shutting down"}},
+	{"no_auth_attempts", []string{"no auth attempts"}},
+}
+
+// Returns the first reason of which a part is contained in the (lowercase) message or an empty string.
+// The fallback messages are only checked if the message contains none of the reasons.
+func dovecotReason(message string, reasons []dovecotReasonClass, fallbacks ...string) string {
+	message = strings.ToLower(message)
+	for _, r := range reasons {
+		for _, part := range r.contains {
+			if strings.Contains(message, part) {
+				return r.reason
+			}
+		}
+	}
+
+	if len(fallbacks) > 0 {
+		return dovecotReason(fallbacks[0], reasons, fallbacks[1:]...)
+	}
+
+	return ""
+}
+
+func (dovecot *Dovecot) count(entry logEntry, add func(counter string, labels ...string)) {
+	process := dovecotProcessPattern.FindStringSubmatch(entry.Message)
+	if process == nil {
+		return
+	}
+	protocol := process[1]
+	isLogin := process[2] == "-login"
+	message := entry.Message[len(process[0]):]
+
+	if strings.Contains(strings.ToLower(message), "quota exceeded") {
+		add("quota", protocol)
+		return
+	}
+
+	if protocol == "auth" {
+		if reason := dovecotReason(message, dovecotAuthFailureReasons); reason != "" {
+			add("failures", protocol, reason)
+		}
+		return
+	}
+
+	if isLogin {
+		if strings.HasPrefix(message, "Login: ") {
+			add("logins", protocol)
+			return
+		}
+
+		match := dovecotLoginReasonPattern.FindStringSubmatch(message)
+		if match == nil {
+			return
+		}
+		// The part in parentheses is more specific than the text, e.g. `Connection closed (auth failed, ...)`
+		if reason := dovecotReason(match[2], dovecotAuthFailureReasons, match[1]); reason != "" {
+			add("failures", protocol, reason)
+			return
+		}
+		reason := dovecotReason(match[2], dovecotDisconnectReasons, match[1])
+		if reason == "" {
+			reason = "other"
+		}
+		add("disconnects", protocol, reason)
+		return
+	}
+
+	if match := dovecotMailDisconnectPattern.FindStringSubmatch(message); match != nil {
+		reason := dovecotReason(match[1], dovecotDisconnectReasons)
+		if reason == "" {
+			reason = "other"
+		}
+		add("disconnects", protocol, reason)
+	}
+}
+
+func (dovecot *Dovecot) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	logins := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_dovecot_logins_total",
+		Help:        "Number of successful logins by protocol (imap, pop3, managesieve, submission)",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"protocol"})
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_dovecot_auth_failures_total",
+		Help:        "Number of failed authentications by protocol and reason. Failures logged by the auth process itself have the protocol `auth`",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"protocol", "reason"})
+	disconnects := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_dovecot_disconnects_total",
+		Help:        "Number of disconnected clients by protocol and reason (e.g. logged_out, connection_closed, inactivity, no_auth_attempts)",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"protocol", "reason"})
+	quota := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_dovecot_quota_exceeded_total",
+		Help:        "Number of mails that could not be saved because the quota of the mailbox was exceeded by protocol (e.g. lmtp, sieve)",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"protocol"})
+	collectors := []prometheus.Collector{logins, failures, disconnects, quota}
+	vecs := map[string]*prometheus.CounterVec{
+		"logins":      logins,
+		"failures":    failures,
+		"disconnects": disconnects,
+		"quota":       quota,
+	}
+
+	body := make([]logEntry, 0)
+	err := api.Get(ctx, fmt.Sprintf("api/v1/get/logs/dovecot/%d", logEntries), &body)
+	if err != nil {
+		dovecot.counters.update(api.Host, nil, nil, vecs)
+		return collectors, err
+	}
+
+	dovecot.counters.update(api.Host, logPositions(body), func(i int, add func(counter string, labels ...string)) {
+		dovecot.count(body[i], add)
+	}, vecs)
+
+	return collectors, nil
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDovecotCount(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected []string
+	}{
+		{"imap-login: Login: user=<a@example.com>, method=PLAIN, rip=1.2.3.4, lip=172.22.1.250, mpid=123, TLS, session=<abc>", []string{"logins imap"}},
+		{"pop3-login: Login: user=<a@example.com>, method=PLAIN, rip=1.2.3.4, lip=172.22.1.250, mpid=123, TLS, session=<abc>", []string{"logins pop3"}},
+		{"imap-login: Disconnected (auth failed, 1 attempts in 2 secs): user=<a@example.com>, method=PLAIN, rip=1.2.3.4, lip=172.22.1.250, TLS, session=<abc>", []string{"failures imap auth_failed"}},
+		{"imap-login: Disconnected: Connection closed (auth failed, 1 attempts in 2 secs): user=<a@example.com>, method=PLAIN, rip=1.2.3.4, lip=172.22.1.250, TLS, session=<abc>", []string{"failures imap auth_failed"}},
+		{"imap-login: Disconnected: Inactivity (no auth attempts in 180 secs): user=<>, rip=1.2.3.4, lip=172.22.1.250, TLS, session=<abc>", []string{"disconnects imap no_auth_attempts"}},
+		{"imap-login: Disconnected: Connection closed (no auth attempts in 0 secs): user=<>, rip=1.2.3.4, lip=172.22.1.250, session=<abc>", []string{"disconnects imap no_auth_attempts"}},
+		{"submission-login: Disconnected: Connection closed: user=<>, rip=1.2.3.4, lip=172.22.1.250, session=<abc>", []string{"disconnects submission connection_closed"}},
+		{"pop3-login: Aborted login (auth failed, 2 attempts in 12 secs): user=<a@example.com>, method=PLAIN, rip=1.2.3.4, lip=172.22.1.250, session=<abc>", []string{"failures pop3 auth_failed"}},
+		{"managesieve-login: Login aborted: Connection closed (tried to use disallowed plaintext auth): user=<>, rip=1.2.3.4, lip=172.22.1.250, session=<abc>", []string{"failures managesieve disallowed_plaintext"}},
+		{"auth: passwd-file(a@example.com,1.2.3.4,<abc>): unknown user", []string{"failures auth unknown_user"}},
+		{"auth-worker(123): sql(a@example.com,1.2.3.4,<abc>): Password mismatch", []string{"failures auth password_mismatch"}},
+		{"auth-worker(123): Debug: sql(a@example.com,1.2.3.4,<abc>): query: SELECT", nil},
+		{"imap(a@example.com)<123><abc>: Disconnected: Logged out in=123 out=456 deleted=0 expunged=0 trashed=0 hdr_count=0 hdr_bytes=0 body_count=0 body_bytes=0", []string{"disconnects imap logged_out"}},
+		{"imap(a@example.com)<123><abc>: Disconnected for inactivity in=123 out=456", []string{"disconnects imap inactivity"}},
+		{"lmtp(a@example.com)<123><abc>: msgid=<def@example.org>: save failed to INBOX: Quota exceeded (mailbox for user is full)", []string{"quota lmtp"}},
+		{"lmtp(123): Connect from local", nil},
+		{"Debug: Loading modules from directory: /usr/lib/dovecot/modules", nil},
+	}
+
+	for _, test := range tests {
+		var counted []string
+		(&Dovecot{}).count(logEntry{Message: test.message}, func(counter string, labels ...string) {
+			counted = append(counted, strings.Join(append([]string{counter}, labels...), " "))
+		})
+
+		if !reflect.DeepEqual(counted, test.expected) {
+			t.Errorf("Expected %v for `%s`, got %v", test.expected, test.message, counted)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fail2ban Provider. This provider uses the `/api/v1/get/fail2ban` endpoint
+// for the fail2ban configuration and active bans and the `/api/v1/get/logs/netfilter/<n>`
+// endpoint for ban events. Only ban events logged since the previous collection are
+// counted, hence this provider must be used as a pointer.
+type Fail2ban struct {
+	counters logCounters
+}
+
+type fail2banResponse struct {
+	BanTime     json.Number   `json:"ban_time"`
+	MaxAttempts json.Number   `json:"max_attempts"`
+	RetryWindow json.Number   `json:"retry_window"`
+	NetbanIpv4  json.Number   `json:"netban_ipv4"`
+	NetbanIpv6  json.Number   `json:"netban_ipv6"`
+	ActiveBans  []fail2banBan `json:"active_bans"`
+	PermBans    []fail2banBan `json:"perm_bans"`
+}
+
+type fail2banBan struct {
+	Network string `json:"network"`
+}
+
+var netfilterBanPattern = regexp.MustCompile(`^Banning (\S+)`)
+
+func (fail2ban *Fail2ban) simpleGauge(host string, name string, description string) prometheus.Gauge {
+	return prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        description,
+		ConstLabels: map[string]string{"host": host},
+	})
+}
+
+func (fail2ban *Fail2ban) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	banTime := fail2ban.simpleGauge(api.Host, "mailcow_fail2ban_ban_time", "Time in seconds a network is banned for")
+	maxAttempts := fail2ban.simpleGauge(api.Host, "mailcow_fail2ban_max_attempts", "Number of failed attempts within the retry window before a network is banned")
+	retryWindow := fail2ban.simpleGauge(api.Host, "mailcow_fail2ban_retry_window", "Time in seconds in which failed attempts are counted")
+	netbanSize := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_fail2ban_netban_size",
+		Help:        "Prefix length of the networks that are banned",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"protocol"})
+	banned := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_fail2ban_banned",
+		Help:        "1 for every network that is currently banned",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"network", "permanent"})
+	bans := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_fail2ban_bans",
+		Help:        "Number of networks that are currently banned",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"permanent"})
+	banEvents := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_fail2ban_ban_events_total",
+		Help:        "Number of times a network has been banned",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"network"})
+	vecs := map[string]*prometheus.CounterVec{"bans": banEvents}
+	collectors := []prometheus.Collector{banTime, maxAttempts, retryWindow, netbanSize, banned, bans, banEvents}
+
+	body := fail2banResponse{}
+	err := api.Get(ctx, "api/v1/get/fail2ban", &body)
+	if err != nil {
+		return collectors, err
+	}
+
+	valueBanTime, err := body.BanTime.Float64()
+	if err != nil {
+		return collectors, err
+	}
+
+	valueMaxAttempts, err := body.MaxAttempts.Float64()
+	if err != nil {
+		return collectors, err
+	}
+
+	valueRetryWindow, err := body.RetryWindow.Float64()
+	if err != nil {
+		return collectors, err
+	}
+
+	valueNetbanIpv4, err := body.NetbanIpv4.Float64()
+	if err != nil {
+		return collectors, err
+	}
+
+	valueNetbanIpv6, err := body.NetbanIpv6.Float64()
+	if err != nil {
+		return collectors, err
+	}
+
+	banTime.Set(valueBanTime)
+	maxAttempts.Set(valueMaxAttempts)
+	retryWindow.Set(valueRetryWindow)
+	netbanSize.WithLabelValues("ipv4").Set(valueNetbanIpv4)
+	netbanSize.WithLabelValues("ipv6").Set(valueNetbanIpv6)
+
+	bans.WithLabelValues("0").Set(float64(len(body.ActiveBans)))
+	bans.WithLabelValues("1").Set(float64(len(body.PermBans)))
+	for _, ban := range body.ActiveBans {
+		banned.WithLabelValues(ban.Network, "0").Set(1.0)
+	}
+	for _, ban := range body.PermBans {
+		banned.WithLabelValues(ban.Network, "1").Set(1.0)
+	}
+
+	logs := make([]logEntry, 0)
+	err = api.Get(ctx, fmt.Sprintf("api/v1/get/logs/netfilter/%d", logEntries), &logs)
+	if err != nil {
+		fail2ban.counters.update(api.Host, nil, nil, vecs)
+		return collectors, err
+	}
+
+	fail2ban.counters.update(api.Host, logPositions(logs), func(i int, add func(counter string, labels ...string)) {
+		if match := netfilterBanPattern.FindStringSubmatch(logs[i].Message); match != nil {
+			add("bans", match[1])
+		}
+	}, vecs)
+
+	return collectors, nil
+}
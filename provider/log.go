@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Number of entries requested from the `/api/v1/get/logs/<service>/<n>` endpoints
+// per collection. Entries that were already processed by the previous collection
+// are skipped, so this only needs to cover the entries logged in between.
+const logEntries = 1000
+
+// A single entry returned by the `/api/v1/get/logs/<service>/<n>` endpoints
+type logEntry struct {
+	Time     json.Number `json:"time"`
+	Program  string      `json:"program"`
+	Priority string      `json:"priority"`
+	Message  string      `json:"message"`
+}
+
+// Time and identity of a log entry, used to recognize entries
+// that have already been processed in a previous collection.
+type logPosition struct {
+	time float64
+	id   string
+}
+
+// Returns the positions of the given entries. Entries without a valid time are skipped.
+func logPositions(entries []logEntry) []logPosition {
+	positions := make([]logPosition, len(entries))
+	for i, entry := range entries {
+		t, err := entry.Time.Float64()
+		if err != nil {
+			t = -1
+		}
+		positions[i] = logPosition{time: t, id: entry.Program + "\x00" + entry.Message}
+	}
+
+	return positions
+}
+
+// Remembers the newest log entries processed for a host.
+// Since log times only have a resolution of seconds, the entries of the newest
+// second are remembered as well in order to not count them twice.
+type logCursor struct {
+	initialized bool
+	time        float64
+	// Number of entries per id with the time of the cursor
+	seen map[string]int
+}
+
+// Returns the indexes of the positions that are newer than the cursor and moves
+// the cursor to the newest position. The first call only sets the cursor, so that
+// the entries logged before the exporter was started are not counted.
+func (cursor *logCursor) advance(positions []logPosition) []int {
+	newest := make([]int, 0)
+	remaining := make(map[string]int, len(cursor.seen))
+	for id, count := range cursor.seen {
+		remaining[id] = count
+	}
+	for i, position := range positions {
+		if position.time < 0 || position.time < cursor.time {
+			continue
+		}
+		if position.time == cursor.time && remaining[position.id] > 0 {
+			remaining[position.id]--
+			continue
+		}
+		newest = append(newest, i)
+	}
+
+	latest := cursor.time
+	for _, position := range positions {
+		if position.time > latest {
+			latest = position.time
+		}
+	}
+	seen := make(map[string]int)
+	for _, position := range positions {
+		if position.time == latest {
+			seen[position.id]++
+		}
+	}
+	// Entries of the newest second that are no longer returned must not be forgotten.
+	if latest == cursor.time {
+		for id, count := range cursor.seen {
+			if seen[id] < count {
+				seen[id] = count
+			}
+		}
+	}
+
+	initialized := cursor.initialized
+	cursor.initialized = true
+	cursor.time = latest
+	cursor.seen = seen
+	if !initialized {
+		return nil
+	}
+
+	return newest
+}
+
+// Counters derived from log entries. The counters are kept per host between
+// collections, so that the providers using them must be used as pointers.
+// Hosts whose state is not kept (see `mailcowApi.KeepsState`) start over on every
+// collection, hence no entries are counted for them.
+type logCounters struct {
+	mutex sync.Mutex
+	hosts map[string]*logCountersHost
+}
+
+type logCountersHost struct {
+	cursor logCursor
+	// Values indexed by counter name and label values joined by `\x00`
+	values map[string]map[string]*logCounterValue
+}
+
+type logCounterValue struct {
+	labels []string
+	value  float64
+}
+
+// Passes the index of every position of the host that was not processed before to
+// `count`, which increments counters using `add`. Afterwards, the accumulated values
+// of the host are added to the given counters, which are indexed by their name.
+// If the log could not be read, nil positions only add the accumulated values.
+func (counters *logCounters) update(
+	host string,
+	positions []logPosition,
+	count func(i int, add func(counter string, labels ...string)),
+	vecs map[string]*prometheus.CounterVec,
+) {
+	counters.mutex.Lock()
+	defer counters.mutex.Unlock()
+
+	if counters.hosts == nil {
+		counters.hosts = make(map[string]*logCountersHost)
+	}
+	state, ok := counters.hosts[host]
+	if !ok {
+		state = &logCountersHost{values: make(map[string]map[string]*logCounterValue)}
+		if mailcowApi.KeepsState(host) {
+			counters.hosts[host] = state
+		}
+	}
+
+	add := func(counter string, labels ...string) {
+		if state.values[counter] == nil {
+			state.values[counter] = make(map[string]*logCounterValue)
+		}
+		key := strings.Join(labels, "\x00")
+		if state.values[counter][key] == nil {
+			state.values[counter][key] = &logCounterValue{labels: labels}
+		}
+		state.values[counter][key].value++
+	}
+	if positions != nil {
+		for _, i := range state.cursor.advance(positions) {
+			count(i, add)
+		}
+	}
+
+	for counter, values := range state.values {
+		vec, ok := vecs[counter]
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			vec.WithLabelValues(value.labels...).Add(value.value)
+		}
+	}
+}
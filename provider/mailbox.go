@@ -1,71 +1,306 @@
 package provider
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/j6s/mailcow-exporter/mailcowApi"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Mailbox Provider. This provider uses the `/api/v1/get/mailbox/all`
-// endpoint in order to gather metrics.
-type Mailbox struct{}
+// endpoint in order to gather metrics. The number of mailboxes is limited
+// by `Cardinality`. Aggregated mailboxes are summed up, except for the last
+// login timestamps which contain the latest login of all aggregated mailboxes.
+type Mailbox struct {
+	Cardinality *Cardinality
+}
 
 type mailboxItem struct {
 	Username      string      `json:"username"`
+	Domain        string      `json:"domain"`
+	Active        json.Number `json:"active"`
 	LastImapLogin json.Number `json:"last_imap_login"`
+	LastSmtpLogin json.Number `json:"last_smtp_login"`
+	LastPop3Login json.Number `json:"last_pop3_login"`
+	LastSogoLogin json.Number `json:"last_sogo_login"`
 	Quota         json.Number `json:"quota"`
 	QuotaUsed     json.Number `json:"quota_used"`
-	Messages      json.Number `json:"messages"`
+	// Either a number or `- ` for mailboxes without quota
+	PercentInUse interface{}       `json:"percent_in_use"`
+	Messages     json.Number       `json:"messages"`
+	Attributes   mailboxAttributes `json:"attributes"`
+}
+
+type mailboxAttributes struct {
+	ImapAccess             json.Number `json:"imap_access"`
+	Pop3Access             json.Number `json:"pop3_access"`
+	SmtpAccess             json.Number `json:"smtp_access"`
+	SieveAccess            json.Number `json:"sieve_access"`
+	SogoAccess             json.Number `json:"sogo_access"`
+	TlsEnforceIn           json.Number `json:"tls_enforce_in"`
+	TlsEnforceOut          json.Number `json:"tls_enforce_out"`
+	QuarantineNotification string      `json:"quarantine_notification"`
 }
 
 // All mailbox gauges have the same options anyways.
-func mailboxGauge(name string, description string, host string) prometheus.GaugeVec {
+func mailboxGauge(name string, description string, host string, labels ...string) prometheus.GaugeVec {
 	return *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        name,
 		Help:        description,
 		ConstLabels: map[string]string{"host": host},
-	}, []string{"mailbox"})
+	}, append([]string{"mailbox", "domain"}, labels...))
+}
+
+// Parses `percent_in_use`, which is not a number for mailboxes without quota.
+func mailboxPercentInUse(value interface{}) (float64, bool, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, true, nil
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" || v == "-" {
+			return 0, false, nil
+		}
+		parsed, err := strconv.ParseFloat(v, 64)
+		return parsed, err == nil, err
+	case nil:
+		return 0, false, nil
+	}
+
+	return 0, false, fmt.Errorf("Unexpected percent_in_use value `%v`", value)
+}
+
+// Values of a single mailbox or of several aggregated mailboxes.
+type mailboxTotals struct {
+	domain       string
+	mailboxes    int
+	active       float64
+	quota        float64
+	quotaUsed    float64
+	messages     float64
+	percentInUse float64
+	// Quota and usage of the mailboxes with quota, used to calculate the percentage in use
+	limited       int
+	limitedQuota  float64
+	limitedUsed   float64
+	lastLogins    map[string]float64
+	access        map[string]float64
+	tlsEnforce    map[string]float64
+	notifications map[string]float64
+}
+
+func parseMailbox(m mailboxItem) (mailboxTotals, error) {
+	totals := mailboxTotals{
+		domain:        m.Domain,
+		mailboxes:     1,
+		lastLogins:    make(map[string]float64),
+		access:        make(map[string]float64),
+		tlsEnforce:    make(map[string]float64),
+		notifications: make(map[string]float64),
+	}
+
+	var err error
+	totals.active, err = m.Active.Float64()
+	if err != nil {
+		return totals, err
+	}
+
+	totals.lastLogins["imap"], err = m.LastImapLogin.Float64()
+	if err != nil {
+		return totals, err
+	}
+
+	totals.quota, err = m.Quota.Float64()
+	if err != nil {
+		return totals, err
+	}
+
+	totals.quotaUsed, err = m.QuotaUsed.Float64()
+	if err != nil {
+		return totals, err
+	}
+
+	totals.messages, err = m.Messages.Float64()
+	if err != nil {
+		return totals, err
+	}
+
+	percentInUse, hasQuota, err := mailboxPercentInUse(m.PercentInUse)
+	if err != nil {
+		return totals, err
+	}
+	if hasQuota {
+		totals.percentInUse = percentInUse
+		totals.limited = 1
+		totals.limitedQuota = totals.quota
+		totals.limitedUsed = totals.quotaUsed
+	}
+
+	// The following values are not returned by all mailcow versions and are skipped if missing.
+	optional := []struct {
+		value  json.Number
+		target map[string]float64
+		key    string
+	}{
+		{m.LastSmtpLogin, totals.lastLogins, "smtp"},
+		{m.LastPop3Login, totals.lastLogins, "pop3"},
+		{m.LastSogoLogin, totals.lastLogins, "sogo"},
+		{m.Attributes.ImapAccess, totals.access, "imap"},
+		{m.Attributes.Pop3Access, totals.access, "pop3"},
+		{m.Attributes.SmtpAccess, totals.access, "smtp"},
+		{m.Attributes.SieveAccess, totals.access, "sieve"},
+		{m.Attributes.SogoAccess, totals.access, "sogo"},
+		{m.Attributes.TlsEnforceIn, totals.tlsEnforce, "in"},
+		{m.Attributes.TlsEnforceOut, totals.tlsEnforce, "out"},
+	}
+	for _, o := range optional {
+		if o.value == "" {
+			continue
+		}
+
+		o.target[o.key], err = o.value.Float64()
+		if err != nil {
+			return totals, err
+		}
+	}
+
+	if m.Attributes.QuarantineNotification != "" {
+		totals.notifications[m.Attributes.QuarantineNotification] = 1.0
+	}
+
+	return totals, nil
+}
+
+// Adds the values of another mailbox.
+func (totals *mailboxTotals) add(other mailboxTotals) {
+	if totals.domain != other.domain {
+		totals.domain = ""
+	}
+	totals.mailboxes += other.mailboxes
+	totals.active += other.active
+	totals.quota += other.quota
+	totals.quotaUsed += other.quotaUsed
+	totals.messages += other.messages
+	totals.limited += other.limited
+	totals.limitedQuota += other.limitedQuota
+	totals.limitedUsed += other.limitedUsed
+	if totals.limitedQuota > 0 {
+		totals.percentInUse = 100 * totals.limitedUsed / totals.limitedQuota
+	}
+
+	for protocol, value := range other.lastLogins {
+		if value > totals.lastLogins[protocol] {
+			totals.lastLogins[protocol] = value
+		}
+	}
+	for protocol, value := range other.access {
+		totals.access[protocol] += value
+	}
+	for direction, value := range other.tlsEnforce {
+		totals.tlsEnforce[direction] += value
+	}
+	for interval, value := range other.notifications {
+		totals.notifications[interval] += value
+	}
 }
 
-func (mailbox Mailbox) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+func (mailbox Mailbox) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	active := mailboxGauge("mailcow_mailbox_active", "Active flag for this mailbox", api.Host)
 	lastLogin := mailboxGauge("mailcow_mailbox_last_login", "Timestamp of the last IMAP login for this mailbox", api.Host)
+	lastSmtpLogin := mailboxGauge("mailcow_mailbox_last_smtp_login", "Timestamp of the last SMTP login for this mailbox", api.Host)
+	lastPop3Login := mailboxGauge("mailcow_mailbox_last_pop3_login", "Timestamp of the last POP3 login for this mailbox", api.Host)
+	lastSogoLogin := mailboxGauge("mailcow_mailbox_last_sogo_login", "Timestamp of the last SOGo login for this mailbox", api.Host)
 	quotaAllowed := mailboxGauge("mailcow_mailbox_quota_allowed", "Quota maximum for the mailbox in bytes", api.Host)
 	quotaUsed := mailboxGauge("mailcow_mailbox_quota_used", "Current syze of the mailbox in bytes", api.Host)
+	percentInUse := mailboxGauge("mailcow_mailbox_percent_in_use", "Percentage of the quota in use. Not reported for mailboxes without quota", api.Host)
 	messages := mailboxGauge("mailcow_mailbox_messages", "Number of messages in the mailbox", api.Host)
-	collectors := []prometheus.Collector{lastLogin, quotaAllowed, quotaUsed, messages}
+	access := mailboxGauge("mailcow_mailbox_access", "1 if access via the protocol is allowed for the mailbox, 0 if not", api.Host, "protocol")
+	tlsEnforce := mailboxGauge("mailcow_mailbox_tls_enforce", "1 if TLS is enforced for incoming / outgoing mails of the mailbox, 0 if not", api.Host, "direction")
+	quarantineNotification := mailboxGauge("mailcow_mailbox_quarantine_notification", "Quarantine notification interval of the mailbox (e.g. never, hourly, daily), the value is always 1", api.Host, "interval")
+	lastLogins := map[string]*prometheus.GaugeVec{
+		"imap": &lastLogin,
+		"smtp": &lastSmtpLogin,
+		"pop3": &lastPop3Login,
+		"sogo": &lastSogoLogin,
+	}
+	collectors := []prometheus.Collector{
+		active,
+		lastLogin,
+		lastSmtpLogin,
+		lastPop3Login,
+		lastSogoLogin,
+		quotaAllowed,
+		quotaUsed,
+		percentInUse,
+		messages,
+		access,
+		tlsEnforce,
+		quarantineNotification,
+	}
 
 	body := make([]mailboxItem, 0)
-	err := api.Get("api/v1/get/mailbox/all", &body)
+	err := api.Get(ctx, "api/v1/get/mailbox/all", &body)
 	if err != nil {
 		return collectors, err
 	}
 
+	parsed := make(map[string]mailboxTotals)
+	weights := make(map[string]float64)
 	for _, m := range body {
-		valueLastImapLogin, err := m.LastImapLogin.Float64()
-		if err != nil {
-			return collectors, err
+		if !mailbox.Cardinality.allowed(m.Username) {
+			continue
 		}
 
-		valueQuota, err := m.Quota.Float64()
+		totals, err := parseMailbox(m)
 		if err != nil {
 			return collectors, err
 		}
 
-		valueQuotaUsed, err := m.QuotaUsed.Float64()
-		if err != nil {
-			return collectors, err
+		parsed[m.Username] = totals
+		weights[mailbox.Cardinality.key(m.Username)] += totals.quotaUsed
+	}
+
+	labels := mailbox.Cardinality.labels(weights)
+	aggregated := make(map[string]*mailboxTotals)
+	for username, totals := range parsed {
+		label := labels[mailbox.Cardinality.key(username)]
+		if existing, ok := aggregated[label]; ok {
+			existing.add(totals)
+		} else {
+			t := totals
+			aggregated[label] = &t
 		}
+	}
 
-		valueMessages, err := m.Messages.Float64()
-		if err != nil {
-			return collectors, err
+	for label, totals := range aggregated {
+		domain := ""
+		if mailbox.Cardinality.keepsDomain(label) {
+			domain = totals.domain
 		}
 
-		lastLogin.WithLabelValues(m.Username).Set(valueLastImapLogin)
-		quotaAllowed.WithLabelValues(m.Username).Set(valueQuota)
-		quotaUsed.WithLabelValues(m.Username).Set(valueQuotaUsed)
-		messages.WithLabelValues(m.Username).Set(valueMessages)
+		active.WithLabelValues(label, domain).Set(totals.active)
+		quotaAllowed.WithLabelValues(label, domain).Set(totals.quota)
+		quotaUsed.WithLabelValues(label, domain).Set(totals.quotaUsed)
+		messages.WithLabelValues(label, domain).Set(totals.messages)
+		if totals.limited > 0 {
+			percentInUse.WithLabelValues(label, domain).Set(totals.percentInUse)
+		}
+		for protocol, value := range totals.lastLogins {
+			lastLogins[protocol].WithLabelValues(label, domain).Set(value)
+		}
+		for protocol, value := range totals.access {
+			access.WithLabelValues(label, domain, protocol).Set(value)
+		}
+		for direction, value := range totals.tlsEnforce {
+			tlsEnforce.WithLabelValues(label, domain, direction).Set(value)
+		}
+		for interval, value := range totals.notifications {
+			quarantineNotification.WithLabelValues(label, domain, interval).Set(value)
+		}
 	}
 
 	return collectors, nil
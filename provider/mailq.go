@@ -1,36 +1,179 @@
 package provider
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/j6s/mailcow-exporter/mailcowApi"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Mailq provider.
 // This provider uses the `/api/v1/get/mailq/all` endpoint
-// in order to gather metrics.
-type Mailq struct{}
+// in order to gather metrics. The number of senders and recipient domains is limited by `Cardinality`.
+type Mailq struct {
+	Cardinality *Cardinality
+}
 
 type queueResponseItem struct {
-	QueueName string `json:"queue_name"`
-	Sender    string
+	QueueName   string                   `json:"queue_name"`
+	QueueId     string                   `json:"queue_id"`
+	ArrivalTime json.Number              `json:"arrival_time"`
+	MessageSize json.Number              `json:"message_size"`
+	Sender      string                   `json:"sender"`
+	Recipients  []queueResponseRecipient `json:"recipients"`
+}
+
+type queueResponseRecipient struct {
+	Address     string `json:"address"`
+	DelayReason string `json:"delay_reason"`
+}
+
+// Classes of deferral reasons, checked in order. The first class
+// containing a part of the (lowercase) delay reason is used.
+var deferralReasonClasses = []struct {
+	class    string
+	contains []string
+}{
+	{"dns", []string{"host or domain name not found", "name service error", "no mx"}},
+	{"tls", []string{"tls", "certificate", "ssl"}},
+	{"greylisting", []string{"greylist", "graylist", "try again later"}},
+	{"rate_limit", []string{"rate limit", "too many", "throttl", "temporarily deferred", "4.7.28"}},
+	{"mailbox_full", []string{"quota", "mailbox full", "mailbox is full", "4.2.2", "insufficient"}},
+	{"connection", []string{"timed out", "connection refused", "network is unreachable", "lost connection", "no route to host", "connection reset"}},
+	{"rejected", []string{" said: 4", " said: 5"}},
+}
+
+// Returns the class of the delay reason returned by postfix
+// (e.g. `connect to outlook.com[1.2.3.4]:25: Connection timed out` is `connection`).
+func deferralReasonClass(reason string) string {
+	reason = strings.ToLower(reason)
+	for _, c := range deferralReasonClasses {
+		for _, part := range c.contains {
+			if strings.Contains(reason, part) {
+				return c.class
+			}
+		}
+	}
+
+	return "other"
 }
 
-func (mailq Mailq) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+func (mailq Mailq) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
 	gauge := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        "mailcow_mailq",
 		Help:        "Length of the queue",
 		ConstLabels: map[string]string{"host": api.Host},
 	}, []string{"queue", "sender"})
+	ageHist := *prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "mailcow_mailq_age_seconds",
+		Help: "Time mails in the queue have been queued for in seconds",
+		Buckets: []float64{
+			60,                 // 1 minute
+			(60 * 5),           // 5 minutes
+			(60 * 15),          // 15 minutes
+			(60 * 60),          // 1 hour
+			(60 * 60 * 4),      // 4 hours
+			(60 * 60 * 12),     // 12 hours
+			(60 * 60 * 24),     // 1 day
+			(3 * 60 * 60 * 24), // 3 days
+		},
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"queue"})
+	bytes := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_mailq_bytes",
+		Help:        "Total size of the mails in the queue in bytes",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"queue"})
+	oldest := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_mailq_oldest_age_seconds",
+		Help:        "Time the oldest mail in the queue has been queued for in seconds",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"queue"})
+	recipientDomains := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_mailq_recipients",
+		Help:        "Number of recipients of the mails in the queue by recipient domain",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"queue", "domain"})
+	deferralReasons := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_mailq_deferral_reasons",
+		Help:        "Number of recipients of the mails in the queue by class of the delay reason (e.g. connection, dns, tls, greylisting, rate_limit, mailbox_full, rejected, other)",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"queue", "reason"})
+	collectors := []prometheus.Collector{gauge, ageHist, bytes, oldest, recipientDomains, deferralReasons}
 
 	body := make([]queueResponseItem, 0)
-	err := api.Get("api/v1/get/mailq/all", &body)
+	err := api.Get(ctx, "api/v1/get/mailq/all", &body)
 	if err != nil {
-		return []prometheus.Collector{gauge}, err
+		return collectors, err
+	}
+
+	items := make([]queueResponseItem, 0, len(body))
+	weights := make(map[string]float64)
+	for _, item := range body {
+		if mailq.Cardinality.allowed(item.Sender) {
+			items = append(items, item)
+			weights[mailq.Cardinality.key(item.Sender)]++
+		}
+	}
+
+	labels := mailq.Cardinality.labels(weights)
+	for _, item := range items {
+		gauge.WithLabelValues(item.QueueName, labels[mailq.Cardinality.key(item.Sender)]).Inc()
+	}
+
+	// Recipient domains are aggregated and limited the same way, a spam wave creates as many of them as of senders.
+	domainWeights := make(map[string]float64)
+	for _, item := range body {
+		for _, recipient := range item.Recipients {
+			if mailq.Cardinality.allowed(recipient.Address) {
+				domainWeights[mailq.Cardinality.domainKey(recipient.Address)]++
+			}
+		}
 	}
+	domainLabels := mailq.Cardinality.labels(domainWeights)
 
+	// Sizes, ages and deferral reasons contain no addresses and are therefore not limited by `Cardinality`.
+	now := float64(time.Now().Unix())
+	oldestAge := make(map[string]float64)
 	for _, item := range body {
-		gauge.WithLabelValues(item.QueueName, item.Sender).Inc()
+		bytes.WithLabelValues(item.QueueName).Add(0)
+		if item.MessageSize != "" {
+			size, err := item.MessageSize.Float64()
+			if err != nil {
+				return collectors, err
+			}
+			bytes.WithLabelValues(item.QueueName).Add(size)
+		}
+
+		if item.ArrivalTime != "" {
+			arrival, err := item.ArrivalTime.Float64()
+			if err != nil {
+				return collectors, err
+			}
+
+			age := now - arrival
+			ageHist.WithLabelValues(item.QueueName).Observe(age)
+			if age > oldestAge[item.QueueName] {
+				oldestAge[item.QueueName] = age
+			}
+		}
+
+		for _, recipient := range item.Recipients {
+			if mailq.Cardinality.allowed(recipient.Address) {
+				recipientDomains.WithLabelValues(item.QueueName, domainLabels[mailq.Cardinality.domainKey(recipient.Address)]).Inc()
+			}
+			if recipient.DelayReason != "" {
+				deferralReasons.WithLabelValues(item.QueueName, deferralReasonClass(recipient.DelayReason)).Inc()
+			}
+		}
+	}
+
+	for queue, age := range oldestAge {
+		oldest.WithLabelValues(queue).Set(age)
 	}
 
-	return []prometheus.Collector{gauge}, nil
+	return collectors, nil
 }
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Postfix provider. This provider uses the `/api/v1/get/logs/postfix/<n>`
+// endpoint in order to count deliveries, rejections, authentication failures
+// and connections. Only entries logged since the previous collection are counted,
+// hence this provider must be used as a pointer.
+type Postfix struct {
+	counters logCounters
+}
+
+var (
+	postfixStatusPattern     = regexp.MustCompile(`\bstatus=(sent|deferred|bounced|expired)\b`)
+	postfixRelayPattern      = regexp.MustCompile(`\brelay=([^,\s\[]+)`)
+	postfixRejectPattern     = regexp.MustCompile(`(?:^|: )(?:milter-)?reject: ([A-Za-z-]+)`)
+	postfixSaslPattern       = regexp.MustCompile(`SASL (\S+) authentication failed`)
+	postfixTLSPattern        = regexp.MustCompile(`^(Anonymous|Untrusted|Trusted|Verified) TLS connection established (from|to)\b`)
+	postfixDisconnectPattern = regexp.MustCompile(`^disconnect from `)
+)
+
+// Reduces the relay host of a delivery to its domain (e.g. `example-com.mail.protection.outlook.com`
+// to `outlook.com`) in order to keep the number of series low. Domains below two letter country
+// codes with a short second level (e.g. `example.co.uk`) keep three labels.
+func postfixRelayDomain(relay string) string {
+	relay = strings.ToLower(strings.TrimSuffix(relay, "."))
+	if net.ParseIP(relay) != nil {
+		return relay
+	}
+
+	labels := strings.Split(relay, ".")
+	if len(labels) <= 2 {
+		return relay
+	}
+
+	keep := 2
+	if len(labels[len(labels)-1]) == 2 && len(labels[len(labels)-2]) <= 3 {
+		keep = 3
+	}
+
+	return strings.Join(labels[len(labels)-keep:], ".")
+}
+
+// Returns the name of the smtpd service from the program, e.g. `submission` for `postfix/submission/smtpd`.
+func postfixService(program string) string {
+	parts := strings.Split(program, "/")
+	if len(parts) == 3 {
+		return parts[1]
+	}
+
+	return "smtp"
+}
+
+func (postfix *Postfix) count(entry logEntry, add func(counter string, labels ...string)) {
+	if match := postfixStatusPattern.FindStringSubmatch(entry.Message); match != nil {
+		relay := "none"
+		if relayMatch := postfixRelayPattern.FindStringSubmatch(entry.Message); relayMatch != nil && relayMatch[1] != "none" {
+			relay = postfixRelayDomain(relayMatch[1])
+		}
+		add("deliveries", match[1], relay)
+		return
+	}
+
+	if match := postfixRejectPattern.FindStringSubmatch(entry.Message); match != nil {
+		add("rejected", strings.ToLower(match[1]))
+		return
+	}
+
+	if match := postfixSaslPattern.FindStringSubmatch(entry.Message); match != nil {
+		add("sasl", strings.ToLower(match[1]))
+		return
+	}
+
+	if match := postfixTLSPattern.FindStringSubmatch(entry.Message); match != nil {
+		direction := "incoming"
+		if match[2] == "to" {
+			direction = "outgoing"
+		}
+		add("tls", direction, strings.ToLower(match[1]))
+		return
+	}
+
+	if strings.HasSuffix(entry.Program, "smtpd") && postfixDisconnectPattern.MatchString(entry.Message) {
+		service := postfixService(entry.Program)
+		tls := "0"
+		if service == "smtps" || strings.Contains(entry.Message, " starttls=") {
+			tls = "1"
+		}
+		add("connections", service, tls)
+	}
+}
+
+func (postfix *Postfix) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	deliveries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_postfix_deliveries_total",
+		Help:        "Number of delivery attempts by status (sent, deferred, bounced, expired) and domain of the relay host",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"status", "relay"})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_postfix_rejected_total",
+		Help:        "Number of mails rejected by postfix or a milter by SMTP stage (e.g. rcpt, end-of-message)",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"stage"})
+	saslFailures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_postfix_sasl_auth_failures_total",
+		Help:        "Number of failed SASL authentications by mechanism",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"mechanism"})
+	tlsConnections := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_postfix_tls_connections_total",
+		Help:        "Number of established TLS connections by direction and trust level (anonymous, untrusted, trusted, verified)",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"direction", "trust"})
+	connections := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_postfix_connections_total",
+		Help:        "Number of finished incoming connections by service (smtp, submission, smtps) and whether TLS was used",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"service", "tls"})
+	collectors := []prometheus.Collector{deliveries, rejected, saslFailures, tlsConnections, connections}
+	vecs := map[string]*prometheus.CounterVec{
+		"deliveries":  deliveries,
+		"rejected":    rejected,
+		"sasl":        saslFailures,
+		"tls":         tlsConnections,
+		"connections": connections,
+	}
+
+	body := make([]logEntry, 0)
+	err := api.Get(ctx, fmt.Sprintf("api/v1/get/logs/postfix/%d", logEntries), &body)
+	if err != nil {
+		postfix.counters.update(api.Host, nil, nil, vecs)
+		return collectors, err
+	}
+
+	postfix.counters.update(api.Host, logPositions(body), func(i int, add func(counter string, labels ...string)) {
+		postfix.count(body[i], add)
+	}, vecs)
+
+	return collectors, nil
+}
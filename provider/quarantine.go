@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"context"
 	"time"
 
 	"github.com/j6s/mailcow-exporter/mailcowApi"
@@ -10,7 +11,10 @@ import (
 // Quarantine Provider. Use `NewQuarantine` to initialize this struct.
 // This provider uses the `/api/v1/get/quarantine/all` endpoint
 // in order to gather metrics about quarantined mails.
-type Quarantine struct{}
+// The number of recipients is limited by `Cardinality`.
+type Quarantine struct {
+	Cardinality *Cardinality
+}
 
 type quarantineItem struct {
 	VirusFlag int     `json:"virus_flag"`
@@ -19,7 +23,7 @@ type quarantineItem struct {
 	Created   int64   `json:"created"`
 }
 
-func (quarantine Quarantine) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+func (quarantine Quarantine) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
 	countGauge := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        "mailcow_quarantine_count",
 		Help:        "Number of mails currently in quarantine",
@@ -53,30 +57,41 @@ func (quarantine Quarantine) Provide(api mailcowApi.MailcowApiClient) ([]prometh
 	}
 
 	body := make([]quarantineItem, 0)
-	err := api.Get("api/v1/get/quarantine/all", &body)
+	err := api.Get(ctx, "api/v1/get/quarantine/all", &body)
 	if err != nil {
 		return collectors, err
 	}
 
+	items := make([]quarantineItem, 0, len(body))
+	weights := make(map[string]float64)
+	for _, q := range body {
+		if quarantine.Cardinality.allowed(q.Recipient) {
+			items = append(items, q)
+			weights[quarantine.Cardinality.key(q.Recipient)]++
+		}
+	}
+	labels := quarantine.Cardinality.labels(weights)
+
 	virus := make(map[string]int)
 	notVirus := make(map[string]int)
-	for _, q := range body {
-		if _, ok := virus[q.Recipient]; !ok {
-			virus[q.Recipient] = 0
+	for _, q := range items {
+		recipient := labels[quarantine.Cardinality.key(q.Recipient)]
+		if _, ok := virus[recipient]; !ok {
+			virus[recipient] = 0
 		}
-		if _, ok := notVirus[q.Recipient]; !ok {
-			notVirus[q.Recipient] = 0
+		if _, ok := notVirus[recipient]; !ok {
+			notVirus[recipient] = 0
 		}
 
 		if q.VirusFlag == 1 {
-			virus[q.Recipient]++
+			virus[recipient]++
 		} else {
-			notVirus[q.Recipient]++
+			notVirus[recipient]++
 		}
 
 		age := time.Now().Unix() - q.Created
-		ageHist.WithLabelValues(q.Recipient).Observe(float64(age))
-		scoreHist.WithLabelValues(q.Recipient).Observe(float64(q.Score))
+		ageHist.WithLabelValues(recipient).Observe(float64(age))
+		scoreHist.WithLabelValues(recipient).Observe(float64(q.Score))
 	}
 
 	for recipient, count := range virus {
@@ -1,6 +1,8 @@
 package provider
 
 import (
+	"context"
+
 	"github.com/j6s/mailcow-exporter/mailcowApi"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -35,33 +37,55 @@ type RspamdResponse struct {
 func (rspamd Rspamd) simpleGauge(
 	host string,
 	name string,
+	description string,
 	value int,
 ) prometheus.Collector {
-	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name:        name,
+		Help:        description,
 		ConstLabels: map[string]string{"host": host},
-	}, func() float64 {
-		return float64(value)
 	})
+	gauge.Set(float64(value))
+
+	return gauge
+}
+
+// rspamd counts since its last start. The values are exported as counters,
+// so that rates do not break when rspamd is restarted and the values are reset.
+func (rspamd Rspamd) simpleCounter(
+	host string,
+	name string,
+	description string,
+	value int,
+) prometheus.Collector {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        name,
+		Help:        description,
+		ConstLabels: map[string]string{"host": host},
+	})
+	counter.Add(float64(value))
+
+	return counter
 }
 
 func (rspamd Rspamd) extractActions(host string, stats RspamdResponse) prometheus.Collector {
-	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name:        "mailcow_rspamd_action",
-		Help:        "Number of items for which a certain action has been taken",
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_rspamd_actions_total",
+		Help:        "Number of scanned mails for which a certain action has been taken since the start of rspamd",
 		ConstLabels: map[string]string{"host": host},
 	}, []string{"action"})
 
 	for action, number := range stats.Actions {
-		gauge.WithLabelValues(action).Set(float64(number))
+		counter.WithLabelValues(action).Add(float64(number))
 	}
 
-	return gauge
+	return counter
 }
 
 func (rspamd Rspamd) extractFuzzyHashes(host string, stats RspamdResponse) prometheus.Collector {
 	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        "mailcow_rspamd_fuzzy_hashes",
+		Help:        "Number of hashes stored in the fuzzy storage",
 		ConstLabels: map[string]string{"host": host},
 	}, []string{"action"})
 
@@ -73,20 +97,22 @@ func (rspamd Rspamd) extractFuzzyHashes(host string, stats RspamdResponse) prome
 }
 
 func (rspamd Rspamd) extractClassification(host string, stats RspamdResponse) prometheus.Collector {
-	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name:        "mailcow_rspamd_classification",
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_rspamd_classification_total",
+		Help:        "Number of mails classified as spam or ham since the start of rspamd",
 		ConstLabels: map[string]string{"host": host},
 	}, []string{"classification"})
 
-	gauge.WithLabelValues("spam").Set(float64(stats.SpamCount))
-	gauge.WithLabelValues("ham").Set(float64(stats.HamCount))
+	counter.WithLabelValues("spam").Add(float64(stats.SpamCount))
+	counter.WithLabelValues("ham").Add(float64(stats.HamCount))
 
-	return gauge
+	return counter
 }
 
 func (rspamd Rspamd) extractPools(host string, stats RspamdResponse) prometheus.Collector {
 	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        "mailcow_rspamd_pools",
+		Help:        "Number of allocated and freed memory pools",
 		ConstLabels: map[string]string{"host": host},
 	}, []string{"state"})
 
@@ -99,6 +125,7 @@ func (rspamd Rspamd) extractPools(host string, stats RspamdResponse) prometheus.
 func (rspamd Rspamd) extractChunks(host string, stats RspamdResponse) prometheus.Collector {
 	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:        "mailcow_rspamd_chunks",
+		Help:        "Number of memory chunks by state",
 		ConstLabels: map[string]string{"host": host},
 	}, []string{"state"})
 
@@ -110,17 +137,21 @@ func (rspamd Rspamd) extractChunks(host string, stats RspamdResponse) prometheus
 	return gauge
 }
 
-func (rspamd Rspamd) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+func (rspamd Rspamd) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
 	body := RspamdResponse{}
-	err := api.Get("api/v1/get/logs/rspamd-stats", &body)
+	err := api.Get(ctx, "api/v1/get/logs/rspamd-stats", &body)
+	if err != nil {
+		// Zero values would look like a reset of rspamd, hence nothing is reported.
+		return []prometheus.Collector{}, err
+	}
 
 	collectors := []prometheus.Collector{
-		rspamd.simpleGauge(api.Host, "mailcow_rspamd_scanned", body.Scanned),
-		rspamd.simpleGauge(api.Host, "mailcow_rspamd_learned", body.Learned),
-		rspamd.simpleGauge(api.Host, "mailcow_rspamd_connections", body.Connections),
-		rspamd.simpleGauge(api.Host, "mailcow_rspamd_control_connections", body.ControlConnections),
-		rspamd.simpleGauge(api.Host, "mailcow_rspamd_bytes_allocated", body.BytesAllocated),
-		rspamd.simpleGauge(api.Host, "mailcow_rspamd_fragmented", body.Fragmented),
+		rspamd.simpleCounter(api.Host, "mailcow_rspamd_scanned_total", "Number of mails scanned since the start of rspamd", body.Scanned),
+		rspamd.simpleCounter(api.Host, "mailcow_rspamd_learned_total", "Number of mails learned since the start of rspamd", body.Learned),
+		rspamd.simpleCounter(api.Host, "mailcow_rspamd_connections_total", "Number of connections to rspamd since its start", body.Connections),
+		rspamd.simpleGauge(api.Host, "mailcow_rspamd_control_connections", "Number of connections to the control interface of rspamd", body.ControlConnections),
+		rspamd.simpleGauge(api.Host, "mailcow_rspamd_bytes_allocated", "Number of bytes allocated by rspamd", body.BytesAllocated),
+		rspamd.simpleGauge(api.Host, "mailcow_rspamd_fragmented", "Number of fragmented memory chunks", body.Fragmented),
 		rspamd.extractChunks(api.Host, body),
 		rspamd.extractPools(api.Host, body),
 		rspamd.extractClassification(api.Host, body),
@@ -128,5 +159,5 @@ func (rspamd Rspamd) Provide(api mailcowApi.MailcowApiClient) ([]prometheus.Coll
 		rspamd.extractFuzzyHashes(api.Host, body),
 	}
 
-	return collectors, err
+	return collectors, nil
 }
@@ -0,0 +1,207 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rspamd history provider. This provider uses the `/api/v1/get/logs/rspamd-history/<n>`
+// endpoint in order to export scores, scan times, actions and symbols of scanned mails.
+// Scans are identified by the message id and scan time, so that scans returned by several
+// collections are only counted once. The values are kept between collections, hence this provider
+// must be used as a pointer.
+type RspamdHistory struct {
+	// Number of the most frequent symbols that are exported. Defaults to 20.
+	TopSymbols int
+
+	mutex sync.Mutex
+	hosts map[string]*rspamdHistoryHost
+}
+
+type rspamdHistoryItem struct {
+	MessageId string                     `json:"message-id"`
+	ScanId    string                     `json:"scan_id"`
+	UnixTime  json.Number                `json:"unix_time"`
+	Score     json.Number                `json:"score"`
+	Action    string                     `json:"action"`
+	TimeReal  json.Number                `json:"time_real"`
+	User      string                     `json:"user"`
+	Size      json.Number                `json:"size"`
+	Ip        string                     `json:"ip"`
+	Sender    string                     `json:"sender_smtp"`
+	Symbols   map[string]json.RawMessage `json:"symbols"`
+}
+
+// Metrics of a single host, kept between collections
+type rspamdHistoryHost struct {
+	initialized bool
+	// Ids of the scans returned by the previous collection
+	seen     map[string]bool
+	score    *prometheus.HistogramVec
+	scanTime *prometheus.HistogramVec
+	actions  *prometheus.CounterVec
+	symbols  map[string]float64
+}
+
+// Returns the id used to recognize a scan. The scan time is part of the id, so that a mail
+// that is scanned again (e.g. after a deferral) is counted as a new scan. Mails without message
+// id are identified by the scan id of rspamd if present, or by their time together with further
+// fields, so that different mails scanned in the same second are told apart.
+func (item rspamdHistoryItem) id() string {
+	if item.MessageId != "" && item.MessageId != "undef" {
+		return item.MessageId + "|" + item.UnixTime.String()
+	}
+	if item.ScanId != "" {
+		return "scan:" + item.ScanId
+	}
+
+	return strings.Join([]string{
+		"time:" + item.UnixTime.String(),
+		item.Score.String(),
+		item.Action,
+		item.User,
+		item.Size.String(),
+		item.Ip,
+		item.Sender,
+	}, "|")
+}
+
+// Mails sent by authenticated users are outbound, all others inbound.
+func (item rspamdHistoryItem) direction() string {
+	if item.User != "" && item.User != "unknown" {
+		return "outbound"
+	}
+
+	return "inbound"
+}
+
+func (history *RspamdHistory) host(host string) *rspamdHistoryHost {
+	if history.hosts == nil {
+		history.hosts = make(map[string]*rspamdHistoryHost)
+	}
+
+	state, ok := history.hosts[host]
+	if !ok {
+		state = &rspamdHistoryHost{
+			seen: make(map[string]bool),
+			score: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:        "mailcow_rspamd_history_score",
+				Help:        "Score of scanned mails by direction (inbound, outbound)",
+				Buckets:     []float64{-5, 0, 2, 4, 6, 8, 10, 15, 20, 30, 50},
+				ConstLabels: map[string]string{"host": host},
+			}, []string{"direction"}),
+			scanTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:        "mailcow_rspamd_history_scan_time_seconds",
+				Help:        "Time it took to scan mails in seconds by direction (inbound, outbound)",
+				Buckets:     []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+				ConstLabels: map[string]string{"host": host},
+			}, []string{"direction"}),
+			actions: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name:        "mailcow_rspamd_history_actions_total",
+				Help:        "Number of scanned mails by action and direction (inbound, outbound)",
+				ConstLabels: map[string]string{"host": host},
+			}, []string{"action", "direction"}),
+			symbols: make(map[string]float64),
+		}
+		// Mails of hosts whose state is not kept are only remembered for a single collection, hence never counted.
+		if mailcowApi.KeepsState(host) {
+			history.hosts[host] = state
+		}
+	}
+
+	return state
+}
+
+// Processes the mails that were not returned by the previous collection.
+// The first collection only remembers the mails, so that mails scanned before
+// the exporter was started are not counted. Mails with invalid values are skipped
+// and the last error is returned.
+func (state *rspamdHistoryHost) update(items []rspamdHistoryItem) error {
+	var lastErr error
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		id := item.id()
+		isNew := !seen[id] && !state.seen[id]
+		seen[id] = true
+		if !isNew || !state.initialized {
+			continue
+		}
+
+		score, err := item.Score.Float64()
+		if err != nil {
+			lastErr = fmt.Errorf("Invalid score of mail %s: %s", id, err.Error())
+			continue
+		}
+		scanTime, err := item.TimeReal.Float64()
+		if err != nil {
+			lastErr = fmt.Errorf("Invalid scan time of mail %s: %s", id, err.Error())
+			continue
+		}
+
+		direction := item.direction()
+		state.actions.WithLabelValues(item.Action, direction).Inc()
+		state.score.WithLabelValues(direction).Observe(score)
+		state.scanTime.WithLabelValues(direction).Observe(scanTime)
+		for symbol := range item.Symbols {
+			state.symbols[symbol]++
+		}
+	}
+
+	state.initialized = true
+	state.seen = seen
+	return lastErr
+}
+
+// Adds the hits of the most frequent symbols to the given counter.
+func (state *rspamdHistoryHost) topSymbols(counter *prometheus.CounterVec, count int) {
+	symbols := make([]string, 0, len(state.symbols))
+	for symbol := range state.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if state.symbols[symbols[i]] != state.symbols[symbols[j]] {
+			return state.symbols[symbols[i]] > state.symbols[symbols[j]]
+		}
+		return symbols[i] < symbols[j]
+	})
+
+	if len(symbols) > count {
+		symbols = symbols[:count]
+	}
+	for _, symbol := range symbols {
+		counter.WithLabelValues(symbol).Add(state.symbols[symbol])
+	}
+}
+
+func (history *RspamdHistory) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	topSymbols := history.TopSymbols
+	if topSymbols == 0 {
+		topSymbols = 20
+	}
+	symbols := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_rspamd_history_symbol_hits_total",
+		Help:        fmt.Sprintf("Number of scanned mails the symbol was found in. Only the %d most frequent symbols are reported", topSymbols),
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"symbol"})
+
+	body := make([]rspamdHistoryItem, 0)
+	err := api.Get(ctx, fmt.Sprintf("api/v1/get/logs/rspamd-history/%d", logEntries), &body)
+
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	state := history.host(api.Host)
+	if err == nil {
+		err = state.update(body)
+	}
+	state.topSymbols(symbols, topSymbols)
+
+	return []prometheus.Collector{state.score, state.scanTime, state.actions, symbols}, err
+}
@@ -0,0 +1,146 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SOGo provider. This provider uses the `/api/v1/get/logs/sogo/<n>` endpoint
+// in order to count webmail logins and ActiveSync requests. Only entries logged
+// since the previous collection are counted, hence this provider must be used as a pointer.
+type Sogo struct {
+	counters logCounters
+}
+
+var (
+	sogoLoginPattern        = regexp.MustCompile(`SOGoRootPage successful login from '[^']*' for user '[^']*'`)
+	sogoLoginFailurePattern = regexp.MustCompile(`SOGoRootPage Login from '[^']*' for user '[^']*' might not have worked`)
+	// Matches ActiveSync requests of the access log, e.g.
+	// `"POST /SOGo/Microsoft-Server-ActiveSync?Cmd=Sync&User=foo&DeviceId=abc&DeviceType=iPhone HTTP/1.1" 200 ...`
+	sogoActiveSyncPattern = regexp.MustCompile(`"[A-Z]+ /SOGo/Microsoft-Server-ActiveSync(?:\?([^ "]*))? HTTP/[0-9.]+" ([0-9]{3})`)
+)
+
+// Commands of the ActiveSync protocol. Since the query is sent by the client,
+// all other commands are reported as `other` in order to limit the number of series.
+var sogoActiveSyncCommands = map[string]bool{
+	"CreateCollection": true, "DeleteCollection": true, "Find": true,
+	"FolderCreate": true, "FolderDelete": true, "FolderSync": true, "FolderUpdate": true,
+	"GetAttachment": true, "GetHierarchy": true, "GetItemEstimate": true, "ItemOperations": true,
+	"MeetingResponse": true, "MoveCollection": true, "MoveItems": true, "Ping": true,
+	"Provision": true, "ResolveRecipients": true, "Search": true, "SendMail": true,
+	"Settings": true, "SmartForward": true, "SmartReply": true, "Sync": true, "ValidateCert": true,
+}
+
+// Prefixes of common device types, e.g. `SAMSUNGSMG991B` is reported as `SAMSUNG`.
+// All other device types are reported as `other`, for the same reason as the commands.
+var sogoDeviceTypes = []string{
+	"iPhone", "iPad", "iPod", "Android", "SAMSUNG", "WindowsMail", "WindowsOutlook",
+	"Outlook", "WP", "BlackBerry", "TouchDown", "Nine",
+}
+
+// Returns the command as reported in the label, e.g. `Sync`, `none` or `other`.
+func sogoCommand(command string) string {
+	if command == "" {
+		return "none"
+	}
+	if !sogoActiveSyncCommands[command] {
+		return otherLabel
+	}
+
+	return command
+}
+
+// Returns the device type as reported in the label, e.g. `iPhone`, `unknown` or `other`.
+func sogoDeviceType(deviceType string) string {
+	if deviceType == "" {
+		return "unknown"
+	}
+	for _, known := range sogoDeviceTypes {
+		if strings.HasPrefix(strings.ToLower(deviceType), strings.ToLower(known)) {
+			return known
+		}
+	}
+
+	return otherLabel
+}
+
+func (sogo *Sogo) count(entry logEntry, add func(counter string, labels ...string)) {
+	if sogoLoginPattern.MatchString(entry.Message) {
+		add("logins")
+		return
+	}
+
+	if sogoLoginFailurePattern.MatchString(entry.Message) {
+		add("failures")
+		return
+	}
+
+	match := sogoActiveSyncPattern.FindStringSubmatch(entry.Message)
+	if match == nil {
+		return
+	}
+
+	// Some clients send base64 encoded queries, which are reported as `unknown`.
+	query, _ := url.ParseQuery(match[1])
+	deviceType := sogoDeviceType(query.Get("DeviceType"))
+	command := sogoCommand(query.Get("Cmd"))
+
+	add("requests", deviceType, command)
+	if status, err := strconv.Atoi(match[2]); err == nil && status >= 400 {
+		add("errors", deviceType, command, match[2])
+	}
+}
+
+func (sogo *Sogo) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	logins := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_sogo_logins_total",
+		Help:        "Number of successful webmail logins",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{})
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_sogo_login_failures_total",
+		Help:        "Number of failed webmail logins",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{})
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_sogo_activesync_requests_total",
+		Help:        "Number of ActiveSync requests by device type and command (e.g. Sync, Ping, FolderSync). Unknown device types and commands are reported as `other`",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"device_type", "command"})
+	activeSyncErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "mailcow_sogo_activesync_errors_total",
+		Help:        "Number of ActiveSync requests that failed with a HTTP status of 400 or above by device type, command and status",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"device_type", "command", "status"})
+	collectors := []prometheus.Collector{logins, failures, requests, activeSyncErrors}
+	vecs := map[string]*prometheus.CounterVec{
+		"logins":   logins,
+		"failures": failures,
+		"requests": requests,
+		"errors":   activeSyncErrors,
+	}
+
+	// Counters without labels are reported even if nothing was logged yet.
+	logins.WithLabelValues()
+	failures.WithLabelValues()
+
+	body := make([]logEntry, 0)
+	err := api.Get(ctx, fmt.Sprintf("api/v1/get/logs/sogo/%d", logEntries), &body)
+	if err != nil {
+		sogo.counters.update(api.Host, nil, nil, vecs)
+		return collectors, err
+	}
+
+	sogo.counters.update(api.Host, logPositions(body), func(i int, add func(counter string, labels ...string)) {
+		sogo.count(body[i], add)
+	}, vecs)
+
+	return collectors, nil
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Syncjob Provider. This provider uses the `/api/v1/get/syncjobs/all-jobs/no_log`
+// endpoint in order to gather metrics about imapsync jobs.
+type Syncjob struct{}
+
+type syncjobItem struct {
+	Id           json.Number `json:"id"`
+	User         string      `json:"user2"`
+	RemoteHost   string      `json:"host1"`
+	Active       json.Number `json:"active"`
+	IsRunning    json.Number `json:"is_running"`
+	LastRun      string      `json:"last_run"`
+	Success      json.Number `json:"success"`
+	ExitStatus   string      `json:"exit_status"`
+	MinsInterval json.Number `json:"mins_interval"`
+}
+
+// Format of the `last_run` date returned by the API
+const syncjobDateFormat = "2006-01-02 15:04:05"
+
+// All syncjob gauges have the same options anyways.
+func syncjobGauge(name string, description string, host string, labels ...string) prometheus.GaugeVec {
+	return *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        description,
+		ConstLabels: map[string]string{"host": host},
+	}, append([]string{"id", "user", "remote_host"}, labels...))
+}
+
+func (syncjob Syncjob) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	active := syncjobGauge("mailcow_syncjob_active", "Active flag for this sync job", api.Host)
+	running := syncjobGauge("mailcow_syncjob_running", "1 if the sync job is currently running, 0 if not", api.Host)
+	lastRun := syncjobGauge("mailcow_syncjob_last_run", "Unix timestamp of the last run of the sync job", api.Host)
+	success := syncjobGauge("mailcow_syncjob_success", "1 if the last run of the sync job was successful, 0 if not", api.Host)
+	exitStatus := syncjobGauge("mailcow_syncjob_exit_status", "Exit status of the last run of the sync job, the value is always 1", api.Host, "exit_status")
+	interval := syncjobGauge("mailcow_syncjob_interval", "Interval between two runs of the sync job in seconds", api.Host)
+	collectors := []prometheus.Collector{active, running, lastRun, success, exitStatus, interval}
+
+	body := make([]syncjobItem, 0)
+	err := api.Get(ctx, "api/v1/get/syncjobs/all-jobs/no_log", &body)
+	if err != nil {
+		return collectors, err
+	}
+
+	for _, s := range body {
+		id := s.Id.String()
+
+		valueActive, err := s.Active.Float64()
+		if err != nil {
+			return collectors, err
+		}
+
+		valueInterval, err := s.MinsInterval.Float64()
+		if err != nil {
+			return collectors, err
+		}
+
+		active.WithLabelValues(id, s.User, s.RemoteHost).Set(valueActive)
+		interval.WithLabelValues(id, s.User, s.RemoteHost).Set(valueInterval * 60)
+
+		if s.IsRunning != "" {
+			valueRunning, err := s.IsRunning.Float64()
+			if err != nil {
+				return collectors, err
+			}
+			running.WithLabelValues(id, s.User, s.RemoteHost).Set(valueRunning)
+		}
+
+		// `last_run`, `success` and `exit_status` are null for jobs that never ran.
+		if s.LastRun != "" {
+			t, err := time.Parse(syncjobDateFormat, s.LastRun)
+			if err != nil {
+				return collectors, err
+			}
+			lastRun.WithLabelValues(id, s.User, s.RemoteHost).Set(float64(t.Unix()))
+		}
+
+		if s.Success != "" {
+			valueSuccess, err := s.Success.Float64()
+			if err != nil {
+				return collectors, err
+			}
+			success.WithLabelValues(id, s.User, s.RemoteHost).Set(valueSuccess)
+		}
+
+		if s.ExitStatus != "" {
+			exitStatus.WithLabelValues(id, s.User, s.RemoteHost, s.ExitStatus).Set(1.0)
+		}
+	}
+
+	return collectors, nil
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Version Provider. This provider uses the `/api/v1/get/status/version`
+// endpoint in order to gather the installed mailcow version.
+// If `ReleasesFile` is set, the version is compared to the release tags
+// listed in that file (one per line) in order to detect available updates.
+type Version struct {
+	ReleasesFile string
+
+	// The releases file is only read again once it changed.
+	mutex           sync.Mutex
+	releases        []string
+	releasesModTime time.Time
+}
+
+type versionResponse struct {
+	Version string `json:"version"`
+}
+
+// Returns the known release tags, reading the releases file if it changed since the last call.
+func (version *Version) knownReleases() ([]string, error) {
+	version.mutex.Lock()
+	defer version.mutex.Unlock()
+
+	info, err := os.Stat(version.ReleasesFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read releases file `%s`: %s", version.ReleasesFile, err.Error())
+	}
+	if info.ModTime().Equal(version.releasesModTime) {
+		return version.releases, nil
+	}
+
+	content, err := ioutil.ReadFile(version.ReleasesFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read releases file `%s`: %s", version.ReleasesFile, err.Error())
+	}
+
+	releases := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			releases = append(releases, line)
+		}
+	}
+
+	version.releases = releases
+	version.releasesModTime = info.ModTime()
+	return releases, nil
+}
+
+func (version *Version) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	info := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_version_info",
+		Help:        "Installed mailcow version, the value is always 1",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"version"})
+	updateAvailable := *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "mailcow_update_available",
+		Help:        "1 if a newer release than the installed version is known, 0 if not. `latest` is the newest known release",
+		ConstLabels: map[string]string{"host": api.Host},
+	}, []string{"latest"})
+	collectors := []prometheus.Collector{info}
+
+	body := versionResponse{}
+	err := api.Get(ctx, "api/v1/get/status/version", &body)
+	if err != nil {
+		return collectors, err
+	}
+
+	info.WithLabelValues(body.Version).Set(1.0)
+
+	if version.ReleasesFile == "" {
+		return collectors, nil
+	}
+	collectors = append(collectors, updateAvailable)
+
+	releases, err := version.knownReleases()
+	if err != nil {
+		return collectors, err
+	}
+
+	// mailcow releases are tagged by date (e.g. `2024-01a`), so they can be compared as strings.
+	latest := body.Version
+	for _, release := range releases {
+		if release > latest {
+			latest = release
+		}
+	}
+
+	if latest != body.Version {
+		updateAvailable.WithLabelValues(latest).Set(1.0)
+	} else {
+		updateAvailable.WithLabelValues(latest).Set(0.0)
+	}
+
+	return collectors, nil
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/j6s/mailcow-exporter/mailcowApi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Vmail Provider. This provider uses the `/api/v1/get/status/vmail`
+// endpoint in order to gather metrics about the vmail volume.
+type Vmail struct{}
+
+type vmailResponse struct {
+	Disk        string `json:"disk"`
+	Used        string `json:"used"`
+	Total       string `json:"total"`
+	UsedPercent string `json:"used_percent"`
+}
+
+// Multipliers of the size suffixes returned by the API.
+// The sizes are taken from `df -H`, which uses powers of 1000.
+var vmailSizeSuffixes = map[string]float64{
+	"":  1,
+	"B": 1,
+	"K": 1e3,
+	"k": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+	"T": 1e12,
+	"P": 1e15,
+}
+
+// Parses human readable sizes such as `2.8G` into bytes.
+func parseVmailSize(size string) (float64, error) {
+	size = strings.TrimSpace(size)
+	number := strings.TrimRight(size, "BKkMGTP")
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse size `%s`: %s", size, err.Error())
+	}
+
+	multiplier, ok := vmailSizeSuffixes[size[len(number):]]
+	if !ok {
+		return 0, fmt.Errorf("Unknown unit in size `%s`", size)
+	}
+
+	return value * multiplier, nil
+}
+
+// All vmail gauges have the same options anyways.
+func vmailGauge(name string, description string, host string) prometheus.GaugeVec {
+	return *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        name,
+		Help:        description,
+		ConstLabels: map[string]string{"host": host},
+	}, []string{"device"})
+}
+
+func (vmail Vmail) Provide(ctx context.Context, api mailcowApi.MailcowApiClient) ([]prometheus.Collector, error) {
+	total := vmailGauge("mailcow_vmail_total", "Size of the vmail volume in bytes", api.Host)
+	used := vmailGauge("mailcow_vmail_used", "Used space on the vmail volume in bytes", api.Host)
+	free := vmailGauge("mailcow_vmail_free", "Free space on the vmail volume in bytes", api.Host)
+	usedPercent := vmailGauge("mailcow_vmail_used_percent", "Used space on the vmail volume in percent", api.Host)
+	collectors := []prometheus.Collector{total, used, free, usedPercent}
+
+	body := vmailResponse{}
+	err := api.Get(ctx, "api/v1/get/status/vmail", &body)
+	if err != nil {
+		return collectors, err
+	}
+
+	valueTotal, err := parseVmailSize(body.Total)
+	if err != nil {
+		return collectors, err
+	}
+
+	valueUsed, err := parseVmailSize(body.Used)
+	if err != nil {
+		return collectors, err
+	}
+
+	valueUsedPercent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(body.UsedPercent), "%"), 64)
+	if err != nil {
+		return collectors, err
+	}
+
+	total.WithLabelValues(body.Disk).Set(valueTotal)
+	used.WithLabelValues(body.Disk).Set(valueUsed)
+	free.WithLabelValues(body.Disk).Set(valueTotal - valueUsed)
+	usedPercent.WithLabelValues(body.Disk).Set(valueUsedPercent)
+
+	return collectors, nil
+}